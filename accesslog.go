@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// writeAccessLog appends one Common or Combined Log Format line for the
+// request to AccessLog. bytes counts the body only, as CLF does.
+func (s *Server) writeAccessLog(request *HTTPRequest, code int, bytes int) {
+	if s.AccessLog == nil {
+		return
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.Itoa(bytes)
+	}
+	line := fmt.Sprintf(`%s - - [%s] "%s" %d %s`,
+		orDash(request.ClientIP),
+		s.now().Format(clfTimeFormat),
+		clfEscape(request.Method+" "+request.Path+" "+request.Version),
+		code,
+		size)
+	if s.AccessLogFormat == AccessLogCombined {
+		line += fmt.Sprintf(` "%s" "%s"`,
+			clfEscape(orDash(request.Headers["referer"])),
+			clfEscape(orDash(request.Headers["user-agent"])))
+	}
+
+	s.accessLogMu.Lock()
+	defer s.accessLogMu.Unlock()
+
+	if _, err := io.WriteString(s.AccessLog, line+"\n"); err != nil {
+		s.Logger.Errorf("Error writing access log: %v", err)
+		return
+	}
+	if f, ok := s.AccessLog.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfEscape keeps client-supplied strings from breaking out of their
+// quotes or onto a new line.
+func clfEscape(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
@@ -0,0 +1,49 @@
+//go:build acme
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const ACMEChallengeAddr = ":80"
+
+func (s *Server) startACME() error {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(s.ACMEDomains...),
+		Cache:      autocert.DirCache(s.ACMECacheDir),
+	}
+
+	challenge, err := net.Listen("tcp", ACMEChallengeAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for ACME challenges on %s: %v", ACMEChallengeAddr, err)
+	}
+
+	s.acme = &http.Server{
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: s.HeaderTimeout,
+		ErrorLog:          log.New(loggerWriter{s.Logger}, "acme: ", 0),
+	}
+
+	go func() {
+		if err := s.acme.Serve(challenge); err != nil && err != http.ErrServerClosed {
+			s.Logger.Errorf("ACME challenge listener error: %v", err)
+		}
+	}()
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	for i, listener := range s.listeners {
+		s.listeners[i] = tls.NewListener(listener, tlsConfig)
+	}
+
+	s.Logger.Infof("ACME enabled for %v, certificates cached in %s", s.ACMEDomains, s.ACMECacheDir)
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !acme
+
+package main
+
+import "fmt"
+
+func (s *Server) startACME() error {
+	return fmt.Errorf("automatic HTTPS is not available, rebuild with -tags acme")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type archiveFile struct {
+	size    int64
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+// archiveFileInfo describes an archive member for the validators in
+// conditional.go.
+type archiveFileInfo struct {
+	name string
+	*archiveFile
+}
+
+func (fi archiveFileInfo) Name() string       { return path.Base(fi.name) }
+func (fi archiveFileInfo) Size() int64        { return fi.size }
+func (fi archiveFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi archiveFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi archiveFileInfo) IsDir() bool        { return false }
+func (fi archiveFileInfo) Sys() any           { return nil }
+
+type siteArchive struct {
+	files  map[string]*archiveFile
+	closer io.Closer
+}
+
+func NewArchiveServer(port, archivePath string) (*Server, error) {
+	archive, err := openSiteArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	server := NewServer(port, archivePath)
+	server.archive = archive
+	return server, nil
+}
+
+func openSiteArchive(archivePath string) (*siteArchive, error) {
+	lower := strings.ToLower(archivePath)
+
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return openZipArchive(archivePath)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return openTarArchive(archivePath, true)
+	case strings.HasSuffix(lower, ".tar"):
+		return openTarArchive(archivePath, false)
+	}
+
+	return nil, fmt.Errorf("unsupported archive format: %s", archivePath)
+}
+
+func openZipArchive(archivePath string) (*siteArchive, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %v", archivePath, err)
+	}
+
+	archive := &siteArchive{files: make(map[string]*archiveFile), closer: reader}
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		archive.files[archiveName(f.Name)] = &archiveFile{
+			size:    int64(f.UncompressedSize64),
+			modTime: f.Modified,
+			open:    f.Open,
+		}
+	}
+
+	return archive, nil
+}
+
+// Tar archives have no index and cannot be read out of order, so their
+// contents are loaded into memory up front.
+func openTarArchive(archivePath string, gzipped bool) (*siteArchive, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %v", archivePath, err)
+	}
+	defer file.Close()
+
+	var source io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %s: %v", archivePath, err)
+		}
+		defer gz.Close()
+		source = gz
+	}
+
+	archive := &siteArchive{files: make(map[string]*archiveFile)}
+	reader := tar.NewReader(source)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %v", archivePath, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive %s: %v", header.Name, archivePath, err)
+		}
+
+		archive.files[archiveName(header.Name)] = &archiveFile{
+			size:    int64(len(data)),
+			modTime: header.ModTime,
+			open: func() (io.ReadCloser, error) {
+				return memberReader{bytes.NewReader(data)}, nil
+			},
+		}
+	}
+
+	return archive, nil
+}
+
+// memberReader reads a tar member held in memory. Unlike io.NopCloser it
+// keeps the io.ReaderAt that lets applyRange serve a range of it.
+type memberReader struct {
+	*bytes.Reader
+}
+
+func (memberReader) Close() error { return nil }
+
+func archiveName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (a *siteArchive) Close() error {
+	if a.closer != nil {
+		return a.closer.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveArchive(request *HTTPRequest, requestPath string) *HTTPResponse {
+	name := strings.TrimPrefix(requestPath, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+
+	entry, ok := s.archive.files[name]
+	if !ok {
+		return s.createErrorResponse(StatusNotFound, "Not Found")
+	}
+
+	// As in serveFS, a member without a timestamp gets no validators.
+	info := archiveFileInfo{name: name, archiveFile: entry}
+	hasModTime := !entry.modTime.IsZero()
+	if hasModTime {
+		if response := s.notModified(request, "", info); response != nil {
+			return response
+		}
+	}
+
+	reader, err := entry.open()
+	if err != nil {
+		s.Logger.Errorf("Error opening %s from archive: %v", name, err)
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+
+	response := &HTTPResponse{
+		Code:        StatusOK,
+		ContentType: s.getMimeType(name),
+		Headers:     make(map[string]string),
+	}
+	if hasModTime {
+		response.Headers["ETag"] = entityTag(info)
+		response.Headers["Last-Modified"] = lastModified(info)
+	}
+
+	// As for files on disk, large members are streamed. A compressed zip
+	// member cannot be read at an offset, so Range is ignored for those.
+	if s.streamFile(response.ContentType, entry.size) {
+		response.BodyReader = reader
+		response.BodySize = entry.size
+		return response
+	}
+
+	defer reader.Close()
+	response.Body = make([]byte, entry.size)
+	if _, err := io.ReadFull(reader, response.Body); err != nil {
+		s.Logger.Errorf("Error reading %s from archive: %v", name, err)
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+	return response
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestArchiveConditionalGet(t *testing.T) {
+	modTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	archivePath := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "index.html", Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "<h1>archived</h1>")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err := NewArchiveServer(DefaultPort, archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = stdLogger{log.New(io.Discard, "", 0)}
+	defer s.archive.Close()
+
+	get := func(header string) string {
+		return exchange(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"+header+"\r\n")
+	}
+
+	response := get("")
+	if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+		t.Fatalf("status = %q", got)
+	}
+	match := regexp.MustCompile(`\r\nETag: ("[^"]+")\r\n`).FindStringSubmatch(response)
+	if match == nil {
+		t.Fatalf("no ETag in %q", response)
+	}
+	wantLastModified := "Last-Modified: " + modTime.Format(http.TimeFormat) + "\r\n"
+	if !strings.Contains(response, wantLastModified) {
+		t.Errorf("missing %q in %q", wantLastModified, response)
+	}
+
+	for _, header := range []string{
+		"If-None-Match: " + match[1] + "\r\n",
+		"If-Modified-Since: " + modTime.Format(http.TimeFormat) + "\r\n",
+	} {
+		if got := statusLine(get(header)); got != "HTTP/1.1 304 Not Modified" {
+			t.Errorf("with %q: status = %q, want 304", header, got)
+		}
+	}
+	if got := statusLine(get("If-None-Match: \"other\"\r\n")); got != "HTTP/1.1 200 OK" {
+		t.Errorf("with a stale tag: status = %q, want 200", got)
+	}
+}
+
+func TestLargeMembersAreStreamed(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), MinStreamSize/16+1)
+
+	zipPath := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(large)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tarPath := filepath.Join(t.TempDir(), "site.tar")
+	f, err = os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "large.bin", Mode: 0644, Size: int64(len(large)), Typeflag: tar.TypeReg})
+	tw.Write(large)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	servers := map[string]*Server{"fs": NewFSServer(DefaultPort, fstest.MapFS{"large.bin": {Data: large}})}
+	for name, archivePath := range map[string]string{"zip": zipPath, "tar": tarPath} {
+		s, err := NewArchiveServer(DefaultPort, archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.archive.Close()
+		servers[name] = s
+	}
+
+	for name, s := range servers {
+		s.Logger = stdLogger{log.New(io.Discard, "", 0)}
+		request := &HTTPRequest{Method: "GET", Path: "/large.bin", Headers: map[string]string{}}
+		var response *HTTPResponse
+		if s.FS != nil {
+			response = s.serveFS(request, "/large.bin")
+		} else {
+			response = s.serveArchive(request, "/large.bin")
+		}
+		if response.BodyReader == nil || response.Body != nil {
+			t.Errorf("%s: large member read into memory", name)
+		}
+		response.closeBody()
+
+		full := exchange(t, s, "GET /large.bin HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if !strings.HasSuffix(full, "\r\n\r\n"+string(large)) {
+			t.Errorf("%s: streamed body differs from the member", name)
+		}
+	}
+
+	// Members held in memory or in an fs.FS still serve ranges.
+	for _, name := range []string{"fs", "tar"} {
+		partial := exchange(t, servers[name], "GET /large.bin HTTP/1.1\r\nHost: example.com\r\nRange: bytes=16-19\r\nConnection: close\r\n\r\n")
+		if got := statusLine(partial); got != "HTTP/1.1 206 Partial Content" || !strings.HasSuffix(partial, "\r\n\r\n0123") {
+			t.Errorf("%s: range of a streamed member = %q", name, partial)
+		}
+	}
+}
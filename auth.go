@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AuthRule protects everything under Prefix with HTTP Basic
+// authentication. Several rules with the same Prefix admit several users;
+// the realm of the first one is announced.
+type AuthRule struct {
+	Prefix   string
+	Realm    string
+	Username string
+	Password string
+}
+
+// ParseAuthRule parses PREFIX=REALM:USER:PASSWORD, e.g.
+// "/admin/=Staff only:alice:s3cret". The password may contain colons.
+func ParseAuthRule(spec string) (AuthRule, error) {
+	prefix, rest, ok := strings.Cut(spec, "=")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return AuthRule{}, fmt.Errorf("auth rule %q: want /PREFIX=REALM:USER:PASSWORD", spec)
+	}
+	realm, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return AuthRule{}, fmt.Errorf("auth rule %q: want /PREFIX=REALM:USER:PASSWORD", spec)
+	}
+	username, password, ok := strings.Cut(rest, ":")
+	if !ok || username == "" {
+		return AuthRule{}, fmt.Errorf("auth rule %q: want /PREFIX=REALM:USER:PASSWORD", spec)
+	}
+	return AuthRule{Prefix: prefix, Realm: realm, Username: username, Password: password}, nil
+}
+
+// checkAuth returns a 401 when requestPath is under a protected prefix and
+// the request does not carry credentials for it, and nil otherwise. The
+// longest matching prefix decides.
+func (s *Server) checkAuth(request *HTTPRequest, requestPath string) *HTTPResponse {
+	rules := s.authRulesFor(requestPath)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if username, password, ok := basicCredentials(request.Headers["authorization"]); ok {
+		// Check every user without stopping early and compare digests, so
+		// the time taken reveals neither which user matched nor lengths.
+		user, pass := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))
+		granted := 0
+		for _, rule := range rules {
+			wantUser, wantPass := sha256.Sum256([]byte(rule.Username)), sha256.Sum256([]byte(rule.Password))
+			granted |= subtle.ConstantTimeCompare(user[:], wantUser[:]) & subtle.ConstantTimeCompare(pass[:], wantPass[:])
+		}
+		if granted == 1 {
+			return nil
+		}
+	}
+
+	response := s.createErrorResponse(StatusUnauthorized, "Unauthorized")
+	realm := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(rules[0].Realm)
+	response.Headers["WWW-Authenticate"] = fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm)
+	return response
+}
+
+// gateRequest applies the HTTPS redirect and AuthRules to a request that
+// is answered outside handleRequest, so no fast path serves a protected
+// path or plain HTTP that handleRequest would refuse.
+func (s *Server) gateRequest(request *HTTPRequest) *HTTPResponse {
+	if response := s.httpsRedirect(request); response != nil {
+		return response
+	}
+
+	rawPath, _, _ := strings.Cut(request.Path, "?")
+	decodedPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return s.createErrorResponse(StatusBadRequest, "Bad Request")
+	}
+	return s.checkAuth(request, cleanPath(decodedPath))
+}
+
+// authRulesFor returns the rules with the longest prefix covering
+// requestPath, none if the path is not protected.
+func (s *Server) authRulesFor(requestPath string) []AuthRule {
+	var rules []AuthRule
+	longest := -1
+	for _, rule := range s.AuthRules {
+		prefix := strings.TrimSuffix(rule.Prefix, "/")
+		if requestPath != prefix && !strings.HasPrefix(requestPath, prefix+"/") {
+			continue
+		}
+		switch {
+		case len(prefix) > longest:
+			rules, longest = []AuthRule{rule}, len(prefix)
+		case len(prefix) == longest:
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func basicCredentials(header string) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
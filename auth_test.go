@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInternalEndpointsHonourAuthRules(t *testing.T) {
+	s := newTestServer(t)
+	s.StatsPath = "/internal/status"
+	s.VersionPath = "/internal/version"
+	s.AuthRules = []AuthRule{{Prefix: "/internal", Realm: "ops", Username: "admin", Password: "secret"}}
+	credentials := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")) + "\r\n"
+
+	for _, path := range []string{s.StatsPath, s.VersionPath} {
+		request := "GET " + path + " HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"
+		if got := statusLine(exchange(t, s, request+"\r\n")); got != "HTTP/1.1 401 Unauthorized" {
+			t.Errorf("%s without credentials: status = %q, want 401", path, got)
+		}
+		if got := statusLine(exchange(t, s, request+credentials+"\r\n")); got != "HTTP/1.1 200 OK" {
+			t.Errorf("%s with credentials: status = %q, want 200", path, got)
+		}
+	}
+}
+
+func TestMiddlewareWrapsFastPaths(t *testing.T) {
+	s := newTestServer(t)
+	s.StatsPath = DefaultStatsPath
+	s.RegisterStaticResponse("/health", StatusOK, "text/plain", []byte("ok"))
+	s.Use(func(next HandlerFunc) HandlerFunc {
+		return func(request *HTTPRequest) *HTTPResponse {
+			response := next(request)
+			response.Headers["X-Middleware"] = "seen"
+			return response
+		}
+	})
+
+	for _, path := range []string{"/health", DefaultStatsPath} {
+		response := exchange(t, s, "GET "+path+" HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+			t.Errorf("%s: status = %q", path, got)
+		}
+		if !strings.Contains(response, "X-Middleware: seen\r\n") {
+			t.Errorf("%s bypassed middleware: %q", path, response)
+		}
+	}
+}
+
+func TestCountingMiddlewareSeesEveryPath(t *testing.T) {
+	s := newTestServer(t)
+	s.StatsPath = DefaultStatsPath
+	s.RegisterStaticResponse("/health", StatusOK, "text/plain", []byte("ok"))
+	if err := os.WriteFile(filepath.Join(s.Root, "page.html"), []byte("<p>page</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var logged []string
+	counts := make(map[string]int)
+	s.Use(func(next HandlerFunc) HandlerFunc {
+		return func(request *HTTPRequest) *HTTPResponse {
+			response := next(request)
+			mu.Lock()
+			defer mu.Unlock()
+			logged = append(logged, fmt.Sprintf("%s %s %d", request.Method, request.Path, response.Code))
+			counts[request.Path]++
+			return response
+		}
+	})
+
+	requests := []struct {
+		path   string
+		status string
+	}{
+		{"/health", "200 OK"},
+		{DefaultStatsPath, "200 OK"},
+		{"/page.html", "200 OK"},
+		{"/missing.html", "404 Not Found"},
+		{"/health", "200 OK"},
+		{"/page.html", "200 OK"},
+	}
+	for _, r := range requests {
+		response := exchange(t, s, "GET "+r.path+" HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 "+r.status {
+			t.Errorf("%s: status = %q, want %s", r.path, got, r.status)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"GET /health 200",
+		"GET " + DefaultStatsPath + " 200",
+		"GET /page.html 200",
+		"GET /missing.html 404",
+		"GET /health 200",
+		"GET /page.html 200",
+	}
+	if strings.Join(logged, "\n") != strings.Join(want, "\n") {
+		t.Errorf("middleware logged\n%s\nwant\n%s", strings.Join(logged, "\n"), strings.Join(want, "\n"))
+	}
+	wantCounts := map[string]int{"/health": 2, DefaultStatsPath: 1, "/page.html": 2, "/missing.html": 1}
+	for path, n := range wantCounts {
+		if counts[path] != n {
+			t.Errorf("%s counted %d times, want %d", path, counts[path], n)
+		}
+	}
+}
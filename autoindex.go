@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// listDirectory looks for requestPath as a directory in roots, in order,
+// and renders the first one found. It returns nil when no root has such a
+// directory.
+func (s *Server) listDirectory(roots []string, requestPath string) *HTTPResponse {
+	for _, root := range roots {
+		dir, ok := resolvePath(root, requestPath)
+		if !ok {
+			return nil
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			s.Logger.Errorf("Error listing %s: %v", dir, err)
+			return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+		}
+		return &HTTPResponse{
+			Code:        StatusOK,
+			ContentType: "text/html; charset=utf-8",
+			Body:        renderDirListing(requestPath, entries),
+			Headers:     make(map[string]string),
+		}
+	}
+	return nil
+}
+
+func renderDirListing(requestPath string, entries []os.DirEntry) []byte {
+	type item struct {
+		name  string
+		isDir bool
+		info  os.FileInfo
+	}
+
+	var items []item
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || name == HeadersFileName {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		items = append(items, item{name: name, isDir: entry.IsDir(), info: info})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].isDir != items[j].isDir {
+			return items[i].isDir
+		}
+		return items[i].name < items[j].name
+	})
+
+	title := html.EscapeString("Index of " + requestPath)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n    <meta charset=\"UTF-8\">\n    <title>%s</title>\n</head>\n<body>\n", title)
+	fmt.Fprintf(&b, "    <h1>%s</h1>\n    <table>\n", title)
+	b.WriteString("        <tr><th>Name</th><th>Size</th><th>Modified</th></tr>\n")
+	if requestPath != "/" {
+		b.WriteString("        <tr><td><a href=\"../\">../</a></td><td></td><td></td></tr>\n")
+	}
+
+	for _, it := range items {
+		name, href, size := it.name, url.PathEscape(it.name), fmt.Sprintf("%d", it.info.Size())
+		if it.isDir {
+			name, href, size = name+"/", href+"/", "-"
+		}
+		if strings.Contains(href, ":") {
+			// Keep "a:b" from being read as a URL with scheme "a".
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, "        <tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(href), html.EscapeString(name), size,
+			it.info.ModTime().Format("2006-01-02 15:04"))
+	}
+
+	fmt.Fprintf(&b, "    </table>\n    <hr>\n    <div class=\"footer\">%s</div>\n</body>\n</html>\n", ServerName)
+	return []byte(b.String())
+}
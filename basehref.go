@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// BaseHrefRule injects <base href="Href"> into HTML pages served under
+// Prefix, for sites mounted at a subpath or behind a rewriting proxy.
+type BaseHrefRule struct {
+	Prefix string
+	Href   string
+}
+
+// ParseBaseHrefRules parses comma-separated PREFIX=HREF pairs.
+func ParseBaseHrefRules(spec string) ([]BaseHrefRule, error) {
+	var rules []BaseHrefRule
+	for _, pair := range strings.Split(spec, ",") {
+		prefix, href, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || href == "" {
+			return nil, fmt.Errorf("base href rule %q: want /PREFIX=HREF", pair)
+		}
+		rules = append(rules, BaseHrefRule{Prefix: prefix, Href: href})
+	}
+	return rules, nil
+}
+
+func (s *Server) injectBaseHref(request *HTTPRequest, response *HTTPResponse) {
+	if len(s.BaseHrefRules) == 0 || response.Code != StatusOK || response.BodyReader != nil ||
+		!strings.HasPrefix(response.ContentType, "text/html") {
+		return
+	}
+
+	requestPath, _, _ := strings.Cut(request.Path, "?")
+
+	// The longest matching prefix wins.
+	var rule *BaseHrefRule
+	for i := range s.BaseHrefRules {
+		r := &s.BaseHrefRules[i]
+		if strings.HasPrefix(requestPath, r.Prefix) && (rule == nil || len(r.Prefix) > len(rule.Prefix)) {
+			rule = r
+		}
+	}
+	if rule == nil {
+		return
+	}
+
+	lower := bytes.ToLower(response.Body)
+	if bytes.Contains(lower, []byte("<base ")) || bytes.Contains(lower, []byte("<base>")) {
+		return
+	}
+
+	tag := []byte(`<base href="` + html.EscapeString(rule.Href) + `">`)
+
+	at := 0
+	for _, name := range []string{"head", "html"} {
+		if end := openingTagEnd(lower, name); end >= 0 {
+			at = end
+			break
+		}
+	}
+
+	body := make([]byte, 0, len(response.Body)+len(tag))
+	body = append(body, response.Body[:at]...)
+	body = append(body, tag...)
+	response.Body = append(body, response.Body[at:]...)
+}
+
+// openingTagEnd returns the offset just past the first <name> or
+// <name ...> tag in the lower-cased document, or -1. It does not mistake
+// <header> for <head>.
+func openingTagEnd(lower []byte, name string) int {
+	open := []byte("<" + name)
+	for offset := 0; ; {
+		i := bytes.Index(lower[offset:], open)
+		if i < 0 {
+			return -1
+		}
+		i += offset + len(open)
+		if i < len(lower) && (lower[i] == '>' || lower[i] == ' ' || lower[i] == '\t' || lower[i] == '\n' || lower[i] == '\r') {
+			if end := bytes.IndexByte(lower[i:], '>'); end >= 0 {
+				return i + end + 1
+			}
+			return -1
+		}
+		offset = i
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxBodyDrain is how much of a streamed body the handler left unread is
+// discarded to keep the connection; with more left it is closed instead.
+const maxBodyDrain = 256 << 10
+
+var (
+	errLengthRequired  = errors.New("request body without Content-Length")
+	errPayloadTooLarge = errors.New("request body too large")
+)
+
+func methodAllowsBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+// readBody reads the Content-Length bytes that follow the headers of a
+// POST, PUT or PATCH, or leaves a body over MaxBufferedBody for the
+// handler to stream. Chunked bodies are not supported and are refused
+// like a missing length, so the client can retry with one.
+func (s *Server) readBody(reader *bufio.Reader, request *HTTPRequest) error {
+	if !methodAllowsBody(request.Method) {
+		return nil
+	}
+
+	header, ok := request.Headers["content-length"]
+	if !ok {
+		return errLengthRequired
+	}
+	if te := request.Headers["transfer-encoding"]; te != "" && !strings.EqualFold(te, "identity") {
+		return errLengthRequired
+	}
+
+	length, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || length < 0 {
+		return fmt.Errorf("invalid Content-Length %q", header)
+	}
+	if length > s.MaxBodySize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", errPayloadTooLarge, length, s.MaxBodySize)
+	}
+
+	request.bodySize = length
+	if s.MaxBufferedBody > 0 && length > s.MaxBufferedBody {
+		request.BodyReader = &io.LimitedReader{R: reader, N: length}
+		return nil
+	}
+
+	request.Body = make([]byte, length)
+	if _, err := io.ReadFull(reader, request.Body); err != nil {
+		return fmt.Errorf("error reading body: %w", err)
+	}
+	request.BodyReader = bytes.NewReader(request.Body)
+	return nil
+}
+
+// finishBody discards what the handler left unread of a streamed body, so
+// that the next request on the connection starts where it should. It
+// reports false, and the connection must close, when more than
+// maxBodyDrain bytes are left or the rest cannot be read.
+func finishBody(request *HTTPRequest) bool {
+	body, ok := request.BodyReader.(*io.LimitedReader)
+	if !ok || body.N == 0 {
+		return true
+	}
+	if body.N > maxBodyDrain {
+		return false
+	}
+	_, err := io.Copy(io.Discard, body)
+	return err == nil && body.N == 0
+}
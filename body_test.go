@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLargeBodyIsStreamedToHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxBufferedBody = 16
+	s.Handle("POST", "/upload", func(request *HTTPRequest) *HTTPResponse {
+		if request.Body != nil {
+			t.Errorf("%d byte body was buffered", len(request.Body))
+		}
+		data, err := io.ReadAll(request.BodyReader)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte(strconv.Itoa(len(data)) + ":" + string(data[:3]))}
+	})
+	s.Handle("POST", "/small", func(request *HTTPRequest) *HTTPResponse {
+		data, _ := io.ReadAll(request.BodyReader)
+		if string(request.Body) != "tiny" || string(data) != "tiny" {
+			t.Errorf("small body: Body %q, BodyReader %q", request.Body, data)
+		}
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("small")}
+	})
+
+	upload := strings.Repeat("abc", 100)
+	response := exchange(t, s,
+		"POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 300\r\n\r\n"+upload+
+			"POST /small HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\nConnection: close\r\n\r\ntiny")
+
+	if !strings.Contains(response, "\r\n\r\n300:abc") {
+		t.Errorf("streamed upload not read in full:\n%s", response)
+	}
+	if !strings.HasSuffix(response, "\r\n\r\nsmall") {
+		t.Errorf("request after a streamed body was not parsed:\n%s", response)
+	}
+}
+
+func TestUnreadStreamedBodyIsDiscarded(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxBufferedBody = 16
+	s.Handle("PUT", "/ignore", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: http.StatusNoContent}
+	})
+	s.Handle("GET", "/next", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("next")}
+	})
+
+	response := exchange(t, s,
+		"PUT /ignore HTTP/1.1\r\nHost: example.com\r\nContent-Length: 64\r\n\r\n"+strings.Repeat("x", 64)+
+			"GET /next HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(response, "\r\n\r\nnext") {
+		t.Errorf("unread body was taken for the next request:\n%s", response)
+	}
+
+	// Too much left to discard: the connection closes after the response.
+	response = exchange(t, s,
+		"PUT /ignore HTTP/1.1\r\nHost: example.com\r\nContent-Length: "+strconv.Itoa(maxBodyDrain+1)+"\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 204 No Content" {
+		t.Errorf("status = %q, want 204", got)
+	}
+	if !strings.Contains(response, "Connection: close\r\n") {
+		t.Errorf("connection kept open with most of the body unread:\n%s", response)
+	}
+}
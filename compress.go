@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MinCompressSize is the smallest body worth gzipping.
+const MinCompressSize = 1024
+
+// MaxCompressSize is the largest compressible file that is read into
+// memory so it can be gzipped. Larger files are streamed uncompressed.
+const MaxCompressSize = 8 << 20
+
+// gzipResponse compresses the body when the client accepts gzip and the
+// content type is compressible text. Already-compressed formats such as
+// images and archives are left alone.
+func (s *Server) gzipResponse(request *HTTPRequest, response *HTTPResponse) {
+	// Streamed bodies are files too large to compress, sent as they are.
+	if !s.Compress || response.BodyReader != nil || !isCompressible(response.ContentType) {
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	// Handlers may set headers in any case, so these are looked up
+	// case-insensitively.
+	if _, encoded := lookupHeader(response.Headers, "Content-Encoding"); encoded {
+		return
+	}
+	if cacheControl, _ := lookupHeader(response.Headers, "Cache-Control"); hasNoTransform(cacheControl) {
+		return
+	}
+	// Byte ranges refer to the uncompressed file.
+	if _, partial := lookupHeader(response.Headers, "Content-Range"); partial || response.Code == StatusPartialContent {
+		return
+	}
+
+	// The body depends on Accept-Encoding whether or not this particular
+	// response ends up compressed.
+	addVary(response.Headers, "Accept-Encoding")
+
+	if len(response.Body) < MinCompressSize || !acceptsGzip(request.Headers["accept-encoding"]) {
+		return
+	}
+
+	compressed, err := gzipBytes(response.Body, s.CompressionLevel)
+	if err != nil {
+		s.Logger.Errorf("Error compressing response: %v", err)
+		return
+	}
+	if len(compressed) >= len(response.Body) {
+		return
+	}
+
+	response.Body = compressed
+	response.Headers["Content-Encoding"] = "gzip"
+	if tag, ok := response.Headers["ETag"]; ok {
+		response.Headers["ETag"] = gzipETag(tag)
+	}
+}
+
+// lookupHeader returns the value of the response header name, matched
+// case-insensitively.
+func lookupHeader(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// addVary adds field to the Vary header, merging any Vary entries set
+// under other spellings into the canonical key so only one is sent.
+func addVary(headers map[string]string, field string) {
+	var values []string
+	for key, value := range headers {
+		if strings.EqualFold(key, "Vary") {
+			if value != "" {
+				values = append(values, value)
+			}
+			delete(headers, key)
+		}
+	}
+	// Map order is random; keep the merged value stable.
+	sort.Strings(values)
+
+	for _, value := range values {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				headers["Vary"] = strings.Join(values, ", ")
+				return
+			}
+		}
+	}
+	headers["Vary"] = strings.Join(append(values, field), ", ")
+}
+
+// gzipWriters pools gzip writers by compression level, from
+// gzip.HuffmanOnly up, as allocating a writer costs far more than
+// compressing a typical page.
+var gzipWriters [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+// gzipBytes compresses body at level with a pooled writer.
+func gzipBytes(body []byte, level int) ([]byte, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", level)
+	}
+	pool := &gzipWriters[level-gzip.HuffmanOnly]
+
+	var buf bytes.Buffer
+	zw, _ := pool.Get().(*gzip.Writer)
+	if zw == nil {
+		var err error
+		if zw, err = gzip.NewWriterLevel(&buf, level); err != nil {
+			return nil, err
+		}
+	} else {
+		zw.Reset(&buf)
+	}
+	// Reset clears any error and the header, so nothing carries over to
+	// the next response.
+	defer pool.Put(zw)
+
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Server) checkCompressionLevel() error {
+	if !s.Compress {
+		return nil
+	}
+	if s.CompressionLevel != gzip.DefaultCompression &&
+		(s.CompressionLevel < gzip.BestSpeed || s.CompressionLevel > gzip.BestCompression) {
+		return fmt.Errorf("invalid compression level %d: want %d to %d, or %d for the default",
+			s.CompressionLevel, gzip.BestSpeed, gzip.BestCompression, gzip.DefaultCompression)
+	}
+	return nil
+}
+
+// hasNoTransform reports whether a Cache-Control value forbids
+// transforming the response, compression included.
+func hasNoTransform(cacheControl string) bool {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+			return true
+		}
+	}
+	return false
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml",
+		"application/problem+json", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip,
+// honouring q=0 exclusions and the * wildcard.
+func acceptsGzip(header string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			wildcardQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNoTransformSuppressesGzip(t *testing.T) {
+	s := newTestServer(t)
+	page := strings.Repeat("<p>compress me</p>\n", 200)
+	handler := func(cacheControl string) HandlerFunc {
+		return func(*HTTPRequest) *HTTPResponse {
+			response := &HTTPResponse{Code: StatusOK, ContentType: "text/html", Body: []byte(page), Headers: map[string]string{}}
+			if cacheControl != "" {
+				response.Headers["Cache-Control"] = cacheControl
+			}
+			return response
+		}
+	}
+	s.Handle("GET", "/plain", handler(""))
+	s.Handle("GET", "/raw", handler("public, No-Transform"))
+
+	get := func(path string) string {
+		return exchange(t, s, "GET "+path+" HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	}
+	if response := get("/plain"); !strings.Contains(response, "Content-Encoding: gzip\r\n") {
+		t.Errorf("/plain was not compressed")
+	}
+	response := get("/raw")
+	if strings.Contains(response, "Content-Encoding") {
+		t.Errorf("no-transform response was compressed")
+	}
+	if !strings.HasSuffix(response, page) {
+		t.Errorf("no-transform body was altered")
+	}
+}
+
+func TestLowercaseHeadersAreHonouredByGzip(t *testing.T) {
+	s := newTestServer(t)
+	page := strings.Repeat("<p>compress me</p>\n", 200)
+	for dir, headers := range map[string]string{
+		"varied": "vary: Origin\n",
+		"raw":    "cache-control: no-transform\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(s.Root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.Root, dir, HeadersFileName), []byte(headers), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.Root, dir, "index.html"), []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Handlers are not canonicalised like _headers, so cover them too.
+	s.Handle("GET", "/handler-varied", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/html", Body: []byte(page), Headers: map[string]string{"vary": "Origin"}}
+	})
+	s.Handle("GET", "/handler-raw", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/html", Body: []byte(page), Headers: map[string]string{"cache-control": "no-transform"}}
+	})
+
+	get := func(path string) (http.Header, string) {
+		response := exchange(t, s, "GET "+path+" HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+		parsed, err := http.ReadResponse(bufio.NewReader(strings.NewReader(response)), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		header, _, _ := strings.Cut(response, "\r\n\r\n")
+		return parsed.Header, header
+	}
+
+	for _, path := range []string{"/varied/", "/handler-varied"} {
+		headers, raw := get(path)
+		if got := headers.Values("Vary"); len(got) != 1 || got[0] != "Origin, Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want one \"Origin, Accept-Encoding\" (headers %q)", path, got, raw)
+		}
+		if headers.Get("Content-Encoding") != "gzip" {
+			t.Errorf("%s: not compressed", path)
+		}
+	}
+	for _, path := range []string{"/raw/", "/handler-raw"} {
+		if headers, _ := get(path); headers.Get("Content-Encoding") != "" {
+			t.Errorf("%s: no-transform response was compressed", path)
+		}
+	}
+}
+
+func TestGzipResponseHasItsOwnETag(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.Root, "index.html"), []byte(strings.Repeat("<p>hello</p>\n", 200)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(headers string) string {
+		return exchange(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"+headers+"\r\n")
+	}
+	etag := func(response string) string {
+		match := regexp.MustCompile(`\r\nETag: ("[^"]+")\r\n`).FindStringSubmatch(response)
+		if match == nil {
+			t.Fatalf("no ETag in %.200q", response)
+		}
+		return match[1]
+	}
+
+	identity := etag(get(""))
+	gzipped := etag(get("Accept-Encoding: gzip\r\n"))
+	if identity == gzipped {
+		t.Fatalf("identity and gzip responses share the ETag %s", identity)
+	}
+
+	for _, tag := range []string{identity, gzipped} {
+		response := get("Accept-Encoding: gzip\r\nIf-None-Match: " + tag + "\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 304 Not Modified" {
+			t.Errorf("If-None-Match %s: status = %q, want 304", tag, got)
+		} else if got := etag(response); got != tag {
+			t.Errorf("If-None-Match %s: 304 carries ETag %s", tag, got)
+		}
+	}
+}
+
+func TestLargeCompressibleFileIsGzipped(t *testing.T) {
+	s := newTestServer(t)
+	s.Compress = true
+	large := strings.Repeat("console.log('hello');\n", 2*MinStreamSize/22)
+	if err := os.WriteFile(filepath.Join(s.Root, "app.js"), []byte(large), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	response := exchange(t, s, "GET /app.js HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+		t.Fatalf("status = %q, want 200", got)
+	}
+	if !strings.Contains(response, "Content-Encoding: gzip\r\n") {
+		t.Errorf("%d byte script was not compressed", len(large))
+	}
+	if len(response) >= len(large) {
+		t.Errorf("response is %d bytes for a %d byte file", len(response), len(large))
+	}
+
+	if !s.streamFile("image/png", MinStreamSize) {
+		t.Errorf("large image is buffered")
+	}
+	if !s.streamFile("text/html", MaxCompressSize) {
+		t.Errorf("file over MaxCompressSize is buffered")
+	}
+}
+
+func TestDirectoryListingIsGzipped(t *testing.T) {
+	s := newTestServer(t)
+	s.EnableDirListing = true
+	var names []string
+	for i := 0; i < 50; i++ {
+		names = append(names, fmt.Sprintf("files/report-%02d.txt", i))
+	}
+	writeFiles(t, s.Root, names...)
+
+	raw := exchange(t, s, "GET /files/ HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("listing is not gzip: %v", err)
+	}
+	listing, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompressing listing: %v", err)
+	}
+	for _, name := range []string{"report-00.txt", "report-49.txt"} {
+		if !strings.Contains(string(listing), name) {
+			t.Errorf("decoded listing does not mention %s", name)
+		}
+	}
+}
+
+func TestPooledGzipWritersDoNotLeakState(t *testing.T) {
+	pages := []string{strings.Repeat("first page ", 500), strings.Repeat("second ", 300), "x"}
+	for round := 0; round < 3; round++ {
+		for _, page := range pages {
+			compressed, err := gzipBytes([]byte(page), gzip.BestSpeed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil || string(got) != page {
+				t.Fatalf("round %d: round trip of %.20q gave %.20q, %v", round, page, got, err)
+			}
+		}
+	}
+
+	if _, err := gzipBytes([]byte("x"), 42); err == nil {
+		t.Error("level 42 accepted")
+	}
+}
+
+var benchmarkPage = []byte(strings.Repeat("<li><a href=\"/docs/page.html\">A page of documentation</a></li>\n", 200))
+
+func BenchmarkGzipPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := gzipBytes(benchmarkPage, gzip.DefaultCompression); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGzipFresh(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+		if err != nil {
+			b.Fatal(err)
+		}
+		zw.Write(benchmarkPage)
+		if err := zw.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionSlotWait is how long a new connection may wait for a free
+// slot when MaxConnections are already open before it is turned away.
+const ConnectionSlotWait = 100 * time.Millisecond
+
+// acquireSlot reserves one of the MaxConnections slots, waiting up to
+// ConnectionSlotWait. It always succeeds when there is no limit.
+func (s *Server) acquireSlot() bool {
+	if s.connSlots == nil {
+		return true
+	}
+
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	// Every slot is taken. An evicted idle connection frees its slot as
+	// it closes, well within the wait below.
+	if s.EvictIdle {
+		s.evictIdleConn()
+	}
+
+	timer := time.NewTimer(ConnectionSlotWait)
+	defer timer.Stop()
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// newBusyResponse prebuilds the 503 sent to connections there is no slot
+// for, so that turning one away during a flood costs a single write. Its
+// body is BusyBody, else BusyPage, else the built-in page; the ErrorPages
+// entry for 503 is not used, so overload and other 503s can say
+// different things.
+func (s *Server) newBusyResponse() *staticResponse {
+	response := s.createErrorResponse(StatusServiceUnavailable, "Service Unavailable")
+	switch {
+	case s.BusyBody != nil:
+		response.Body = s.BusyBody
+		response.ContentType = s.BusyContentType
+		if response.ContentType == "" {
+			response.ContentType = "text/plain; charset=utf-8"
+		}
+	case s.BusyPage != "":
+		body, contentType, err := s.readSitePage(s.roots(), s.BusyPage)
+		if err != nil {
+			s.Logger.Errorf("Busy page %s unavailable, using the built-in one: %v", s.BusyPage, err)
+			break
+		}
+		response.Body = body
+		response.ContentType = contentType
+	}
+
+	retryAfter := s.BusyRetryAfter
+	if retryAfter == 0 {
+		retryAfter = s.RetryAfter
+	}
+
+	busy := &staticResponse{code: response.Code, contentType: response.ContentType, body: response.Body}
+	busy.head = []byte(fmt.Sprintf("HTTP/1.1 %s\r\n", response.statusLine()))
+
+	fields := ""
+	if response.ContentType != "" {
+		fields += fmt.Sprintf("Content-Type: %s\r\n", response.ContentType)
+	}
+	fields += fmt.Sprintf("Content-Length: %d\r\n", len(response.Body))
+	fields += fmt.Sprintf("Retry-After: %s\r\n", retryAfterSeconds(retryAfter))
+	busy.fields = []byte(fields)
+	return busy
+}
+
+// rejectConnection writes the prebuilt 503 to a connection there is no
+// slot for, without reading the request. Write and drain get short
+// deadlines so a client that stalls cannot keep the goroutine around.
+func (s *Server) rejectConnection(conn net.Conn) {
+	atomic.AddInt64(&s.Stats.RejectedConnections, 1)
+	s.Logger.Errorf("Rejecting connection from %s: %d connections already open", conn.RemoteAddr(), s.MaxConnections)
+
+	conn.SetWriteDeadline(time.Now().Add(ConnectionSlotWait))
+	if err := s.sendStaticResponse(conn, s.busyResponse, false, 0, false); err != nil {
+		conn.Close()
+		return
+	}
+
+	// Closing with the request unread makes the kernel answer it with a
+	// reset, which can destroy the 503 before the client reads it. Signal
+	// the end of the response instead and discard what arrives meanwhile.
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(ConnectionSlotWait))
+	io.CopyN(io.Discard, conn, maxBodyDrain)
+	conn.Close()
+}
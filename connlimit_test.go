@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRejectedConnectionsDoNotBlockAccept(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxConnections = 1
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.Handle("GET", "/block", func(*HTTPRequest) *HTTPResponse {
+		close(entered)
+		<-release
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("done")}
+	})
+	addr, _ := startServer(t, s)
+
+	request := func() string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET /block HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		out, _ := io.ReadAll(conn)
+		return string(out)
+	}
+
+	first := make(chan string, 1)
+	go func() { first <- request() }()
+	<-entered
+
+	// Each rejection waits ConnectionSlotWait; done one after another in
+	// the accept loop, ten of them would take at least a second.
+	const rejected = 10
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < rejected; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := request()
+			if got := statusLine(response); got != "HTTP/1.1 503 Service Unavailable" {
+				t.Errorf("status = %q, want 503", got)
+			}
+			if !strings.Contains(response, "Retry-After: 5\r\n") {
+				t.Errorf("missing Retry-After in %q", response)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 5*ConnectionSlotWait {
+		t.Errorf("%d rejections took %v", rejected, elapsed)
+	}
+	// startServer's readiness probe may have lost the slot as well.
+	if got := atomic.LoadInt64(&s.Stats.RejectedConnections); got < rejected {
+		t.Errorf("RejectedConnections = %d, want at least %d", got, rejected)
+	}
+
+	close(release)
+	if got := statusLine(<-first); got != "HTTP/1.1 200 OK" {
+		t.Errorf("held request: status = %q, want 200", got)
+	}
+}
+
+func TestFullServerEvictsLongestIdleConnection(t *testing.T) {
+	for _, evict := range []bool{true, false} {
+		s := newTestServer(t)
+		s.MaxConnections = 2
+		s.EvictIdle = evict
+		addr, _ := startServer(t, s)
+
+		// Two keep-alive connections take both slots, then go idle.
+		var idle []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+			readResponse(t, reader)
+			idle = append(idle, conn)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if !evict {
+			if got := statusLine(exchangeTCP(t, addr, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); got != "HTTP/1.1 503 Service Unavailable" {
+				t.Errorf("without eviction: status = %q, want 503", got)
+			}
+			continue
+		}
+
+		newcomer := exchangeTCP(t, addr, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if got := statusLine(newcomer); got != "HTTP/1.1 404 Not Found" {
+			t.Errorf("with eviction: status = %q, want the newcomer served", got)
+		}
+		if _, err := idle[0].Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("longest idle connection not closed: %v", err)
+		}
+		idle[1].SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := idle[1].Read(make([]byte, 1)); !isTimeout(err) {
+			t.Errorf("more recently active connection was closed: %v", err)
+		}
+		if got := atomic.LoadInt64(&s.Stats.EvictedConnections); got != 1 {
+			t.Errorf("EvictedConnections = %d, want 1", got)
+		}
+	}
+}
+
+func TestRejectedConnectionGetsCannedBody(t *testing.T) {
+	s := newTestServer(t)
+	s.BusyBody = []byte("busy, try again\n")
+
+	// The request, body included, is never read. Unless the server drains
+	// it, closing makes the client's read fail with a reset.
+	request := "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 65536\r\n\r\n" + strings.Repeat("x", 65536)
+	response := rejectedResponse(t, s, request)
+	if got := statusLine(response); got != "HTTP/1.1 503 Service Unavailable" {
+		t.Fatalf("status = %q, want 503", got)
+	}
+	for _, want := range []string{"\r\nContent-Type: text/plain; charset=utf-8\r\n", "\r\nRetry-After: 5\r\n", "\r\nConnection: close\r\n"} {
+		if !strings.Contains(response, want) {
+			t.Errorf("missing %q in %q", want, response)
+		}
+	}
+	if !strings.HasSuffix(response, "\r\n\r\nbusy, try again\n") {
+		t.Errorf("body of %q is not BusyBody", response)
+	}
+}
+
+func TestRejectedConnectionGetsBusyPage(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.Root, "errors"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"503.html": "<p>generic</p>", "busy.html": "<p>overloaded</p>"} {
+		if err := os.WriteFile(filepath.Join(s.Root, "errors", name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.ErrorPages = map[int]string{StatusServiceUnavailable: "/errors/503.html"}
+	s.BusyPage = "/errors/busy.html"
+	s.BusyRetryAfter = 30 * time.Second
+
+	response := rejectedResponse(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if !strings.HasSuffix(response, "\r\n\r\n<p>overloaded</p>") {
+		t.Errorf("got %q, want the busy page", response)
+	}
+	if !strings.Contains(response, "\r\nRetry-After: 30\r\n") {
+		t.Errorf("missing BusyRetryAfter in %q", response)
+	}
+
+	// Other 503s keep the ErrorPages entry and RetryAfter.
+	s.SetMaintenance(true)
+	response = exchange(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(response, "\r\n\r\n<p>generic</p>") || !strings.Contains(response, "\r\nRetry-After: 5\r\n") {
+		t.Errorf("maintenance 503 = %q, want the ErrorPages page and the default Retry-After", response)
+	}
+}
+
+// rejectedResponse starts s with a single connection slot, holds it with
+// a blocked request and returns the reply to raw on a second connection.
+func rejectedResponse(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	s.MaxConnections = 1
+	s.EvictIdle = false
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.Handle("GET", "/block", func(*HTTPRequest) *HTTPResponse {
+		close(entered)
+		<-release
+		return &HTTPResponse{Code: StatusOK}
+	})
+	addr, _ := startServer(t, s)
+	// Cleanups run last first: unblock the handler before shutdown.
+	t.Cleanup(func() { close(release) })
+
+	holder, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { holder.Close() })
+	io.WriteString(holder, "GET /block HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	<-entered
+
+	return exchangeTCP(t, addr, raw)
+}
+
+// exchangeTCP sends raw on a new connection to addr and returns the reply
+// read until the server closes.
+func exchangeTCP(t *testing.T, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return roundTrip(t, conn, raw)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
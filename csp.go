@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+)
+
+const CSPNoncePlaceholder = "{nonce}"
+
+type cspNonceKey struct{}
+
+// assignCSPNonce gives the request a fresh random nonce for
+// Content-Security-Policy, readable by handlers through CSPNonce.
+func (s *Server) assignCSPNonce(request *HTTPRequest) error {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	request.SetValue(cspNonceKey{}, base64.StdEncoding.EncodeToString(buf))
+	return nil
+}
+
+// CSPNonce returns the nonce assigned to the request, or "" when
+// Server.CSPTemplate is not configured.
+func CSPNonce(request *HTTPRequest) string {
+	nonce, _ := request.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+func (s *Server) applyCSP(request *HTTPRequest, response *HTTPResponse) {
+	nonce := CSPNonce(request)
+	if nonce == "" {
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	response.Headers["Content-Security-Policy"] = strings.ReplaceAll(s.CSPTemplate, CSPNoncePlaceholder, nonce)
+}
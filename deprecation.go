@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeprecationRule announces that paths matching Pattern (path.Match
+// syntax) are deprecated since Deprecated and/or go away at Sunset. Zero
+// times are not sent.
+type DeprecationRule struct {
+	Pattern    string
+	Deprecated time.Time
+	Sunset     time.Time
+}
+
+// ParseDeprecationRules parses comma-separated PATTERN=DEPRECATED/SUNSET
+// rules with YYYY-MM-DD dates, either of which may be left empty, e.g.
+// "/api/v1/*=2026-01-01/2026-06-30".
+func ParseDeprecationRules(spec string) ([]DeprecationRule, error) {
+	var rules []DeprecationRule
+	for _, item := range strings.Split(spec, ",") {
+		pattern, dates, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("deprecation rule %q: want PATTERN=DEPRECATED/SUNSET", item)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("deprecation rule %q: %v", item, err)
+		}
+
+		rule := DeprecationRule{Pattern: pattern}
+		deprecated, sunset, _ := strings.Cut(dates, "/")
+		for _, d := range []struct {
+			value string
+			dst   *time.Time
+		}{{deprecated, &rule.Deprecated}, {sunset, &rule.Sunset}} {
+			if d.value == "" {
+				continue
+			}
+			t, err := time.Parse("2006-01-02", d.value)
+			if err != nil {
+				return nil, fmt.Errorf("deprecation rule %q: %v", item, err)
+			}
+			*d.dst = t
+		}
+		if rule.Deprecated.IsZero() && rule.Sunset.IsZero() {
+			return nil, fmt.Errorf("deprecation rule %q: needs a deprecation or sunset date", item)
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// applyDeprecation adds the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers of the first rule matching the request path.
+func (s *Server) applyDeprecation(request *HTTPRequest, response *HTTPResponse) {
+	if len(s.DeprecationRules) == 0 {
+		return
+	}
+
+	requestPath, _, _ := strings.Cut(request.Path, "?")
+	for _, rule := range s.DeprecationRules {
+		if matched, _ := path.Match(rule.Pattern, requestPath); !matched {
+			continue
+		}
+
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		if !rule.Deprecated.IsZero() {
+			response.Headers["Deprecation"] = "@" + strconv.FormatInt(rule.Deprecated.Unix(), 10)
+		}
+		if !rule.Sunset.IsZero() {
+			response.Headers["Sunset"] = rule.Sunset.UTC().Format(http.TimeFormat)
+		}
+		return
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// applyErrorPage replaces the body of an error response with the page
+// configured in ErrorPages for its code, read from the roots of the
+// request's host. request is nil when the request could not be parsed,
+// and the default roots are used.
+func (s *Server) applyErrorPage(request *HTTPRequest, response *HTTPResponse) {
+	if response.errorMessage == "" || response.BodyReader != nil {
+		return
+	}
+	if _, configured := s.ErrorPages[response.Code]; !configured {
+		return
+	}
+
+	roots := s.roots()
+	if request != nil {
+		roots = s.rootsFor(request)
+	}
+	if body, contentType, ok := s.customErrorPage(roots, response.Code); ok {
+		response.Body = body
+		response.ContentType = contentType
+	}
+}
+
+// customErrorPage returns the body of the page configured in ErrorPages
+// for code, read from wherever the site is served from. ok is false when
+// none is configured or it is not a readable regular file, and the
+// built-in page is used.
+func (s *Server) customErrorPage(roots []string, code int) (body []byte, contentType string, ok bool) {
+	page, configured := s.ErrorPages[code]
+	if !configured {
+		return nil, "", false
+	}
+
+	body, contentType, err := s.readSitePage(roots, page)
+	if err != nil {
+		s.logErrorPageOnce(code, roots, err)
+		return nil, "", false
+	}
+	return body, contentType, true
+}
+
+// readSitePage reads page, a path under the site such as
+// "/errors/404.html", from the FS, the archive or the first of roots that
+// has it as a regular file.
+func (s *Server) readSitePage(roots []string, page string) (body []byte, contentType string, err error) {
+	requestPath := cleanPath(page)
+	name := strings.TrimPrefix(requestPath, "/")
+
+	switch {
+	case s.FS != nil:
+		var info fs.FileInfo
+		if info, err = fs.Stat(s.FS, name); err == nil {
+			if err = checkRegularFile(name, info); err == nil {
+				body, err = fs.ReadFile(s.FS, name)
+			}
+		}
+	case s.archive != nil:
+		body, err = s.archive.readFile(name)
+	default:
+		err = fs.ErrNotExist
+		for _, root := range roots {
+			filePath, contained := resolvePath(root, requestPath)
+			if !contained {
+				continue
+			}
+			var info os.FileInfo
+			if info, err = os.Stat(filePath); err != nil {
+				continue
+			}
+			if err = checkRegularFile(filePath, info); err != nil {
+				continue
+			}
+			if body, err = os.ReadFile(filePath); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, s.getMimeType(name), nil
+}
+
+// checkRegularFile refuses anything but a regular file as an error page:
+// reading a FIFO or device could block the response indefinitely.
+func checkRegularFile(name string, info fs.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file (%v)", name, info.Mode().Type())
+	}
+	return nil
+}
+
+// logErrorPageOnce reports an unusable error page the first time it is
+// hit for a set of roots rather than on every error response.
+func (s *Server) logErrorPageOnce(code int, roots []string, err error) {
+	key := fmt.Sprintf("%d %q", code, roots)
+
+	s.errorPageMu.Lock()
+	defer s.errorPageMu.Unlock()
+
+	if s.errorPageLogged[key] {
+		return
+	}
+	if s.errorPageLogged == nil {
+		s.errorPageLogged = make(map[string]bool)
+	}
+	s.errorPageLogged[key] = true
+	s.Logger.Errorf("Error page for %d unavailable, using the built-in one: %v", code, err)
+}
+
+func (a *siteArchive) readFile(name string) ([]byte, error) {
+	entry, ok := a.files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	reader, err := entry.open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
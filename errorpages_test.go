@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorPageUsesVirtualHostRoot(t *testing.T) {
+	s := newTestServer(t)
+	s.ErrorPages = map[int]string{StatusNotFound: "/404.html"}
+	vhost := t.TempDir()
+	s.AddVHost("other.example", vhost)
+	if err := os.WriteFile(filepath.Join(s.Root, "404.html"), []byte("default site 404"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(vhost, "404.html"), []byte("other site 404"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]string{
+		"example.com":   "default site 404",
+		"other.example": "other site 404",
+	} {
+		response := exchange(t, s, "GET /missing HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 404 Not Found" {
+			t.Errorf("%s: status = %q, want 404", host, got)
+		}
+		if !strings.HasSuffix(response, want) {
+			t.Errorf("%s: body = %q, want %q", host, response[strings.Index(response, "\r\n\r\n")+4:], want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorPageMustBeRegularFile(t *testing.T) {
+	s := newTestServer(t)
+	var logged bytes.Buffer
+	s.Logger = stdLogger{log.New(&logged, "", 0)}
+	s.ErrorPages = map[int]string{StatusNotFound: "/404.html"}
+	if err := syscall.Mkfifo(filepath.Join(s.Root, "404.html"), 0644); err != nil {
+		t.Skipf("cannot create a FIFO: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		response := exchange(t, s, "GET /missing HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 404 Not Found" {
+			t.Fatalf("status = %q, want 404", got)
+		}
+		if !strings.Contains(response, "<h1>404 Not Found</h1>") {
+			t.Errorf("response does not carry the built-in page")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("three 404s took %v", elapsed)
+	}
+
+	if n := strings.Count(logged.String(), "Error page for 404 unavailable"); n != 1 {
+		t.Errorf("unusable error page logged %d times, want once:\n%s", n, logged.String())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// NewFSServer serves the site from fsys, such as an embed.FS or an
+// fstest.MapFS, instead of a directory on disk.
+func NewFSServer(port string, fsys fs.FS) *Server {
+	server := NewServer(port, "")
+	server.FS = fsys
+	return server
+}
+
+func (s *Server) serveFS(request *HTTPRequest, requestPath string) *HTTPResponse {
+	name := strings.TrimPrefix(requestPath, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+
+	info, err := fs.Stat(s.FS, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return s.createErrorResponse(StatusNotFound, "Not Found")
+	}
+
+	// embed.FS reports a zero mtime, which makes a useless validator: a
+	// rebuilt binary could serve different content under the same tag.
+	hasModTime := !info.ModTime().IsZero()
+	if hasModTime {
+		if response := s.notModified(request, "", info); response != nil {
+			return response
+		}
+	}
+
+	file, err := s.FS.Open(name)
+	if err != nil {
+		s.Logger.Errorf("Error opening %s: %v", name, err)
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+
+	response := &HTTPResponse{
+		Code:        StatusOK,
+		ContentType: s.getMimeType(name),
+		Headers:     make(map[string]string),
+	}
+	if hasModTime {
+		response.Headers["ETag"] = entityTag(info)
+		response.Headers["Last-Modified"] = lastModified(info)
+	}
+
+	// As for files on disk, large files are streamed; ranges of them are
+	// served when the file is an io.ReaderAt, as embed.FS files are.
+	if s.streamFile(response.ContentType, info.Size()) {
+		response.BodyReader = file
+		response.BodySize = info.Size()
+		return response
+	}
+
+	defer file.Close()
+	if response.Body, err = io.ReadAll(file); err != nil {
+		s.Logger.Errorf("Error reading %s: %v", name, err)
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+	return response
+}
@@ -1,3 +1,11 @@
 module simplehttp
 
-go 1.21
\ No newline at end of file
+go 1.21
+
+require golang.org/x/sys v0.28.0
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+)
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// wantsKeepAlive reports whether the connection may carry another request
+// after this one: HTTP/1.1 unless the client sent Connection: close,
+// HTTP/1.0 only when it asked for keep-alive. A connection opened more
+// than MaxConnLifetime ago is closed after this request.
+func (s *Server) wantsKeepAlive(request *HTTPRequest, served int, opened time.Time) bool {
+	if s.IdleTimeout <= 0 || !s.keepOpen(opened) {
+		return false
+	}
+	if s.MaxKeepAliveRequests > 0 && served+1 >= s.MaxKeepAliveRequests {
+		return false
+	}
+
+	// Only POST, PUT and PATCH bodies are read, so whatever follows the
+	// headers of another request with a body cannot be parsed as the next
+	// request.
+	if !methodAllowsBody(request.Method) {
+		if length := request.Headers["content-length"]; (length != "" && length != "0") ||
+			request.Headers["transfer-encoding"] != "" {
+			return false
+		}
+	}
+
+	var closeRequested, keepAliveRequested bool
+	for _, token := range strings.Split(request.Headers["connection"], ",") {
+		switch strings.ToLower(strings.TrimSpace(token)) {
+		case "close":
+			closeRequested = true
+		case "keep-alive":
+			keepAliveRequested = true
+		}
+	}
+
+	switch request.Version {
+	case "HTTP/1.1":
+		return !closeRequested
+	case "HTTP/1.0":
+		return keepAliveRequested && !closeRequested
+	}
+	return false
+}
+
+// keepOpen reports whether a connection accepted at opened may still
+// carry another request: the server is not shutting down and the
+// connection is younger than MaxConnLifetime. It is checked again as the
+// response is written, since either can change while the handler runs.
+func (s *Server) keepOpen(opened time.Time) bool {
+	if s.isShuttingDown() {
+		return false
+	}
+	return s.MaxConnLifetime <= 0 || time.Since(opened) < s.MaxConnLifetime
+}
+
+// connectionFields is the Connection header for a response on a connection
+// that has already carried served requests. A kept-alive connection also
+// gets Keep-Alive with the idle timeout and, when MaxKeepAliveRequests
+// caps it, how many more requests it will carry.
+func (s *Server) connectionFields(keepAlive bool, served int) string {
+	if !keepAlive {
+		return "Connection: close\r\n"
+	}
+
+	fields := "Connection: keep-alive\r\n"
+	fields += fmt.Sprintf("Keep-Alive: timeout=%d", int(s.IdleTimeout/time.Second))
+	if s.MaxKeepAliveRequests > 0 {
+		fields += fmt.Sprintf(", max=%d", s.MaxKeepAliveRequests-served-1)
+	}
+	return fields + "\r\n"
+}
+
+// awaitNextRequest waits up to IdleTimeout for the first byte of the next
+// request on a keep-alive connection. It gives up early when the server
+// starts shutting down, but a request that has already arrived is still
+// answered, with Connection: close. The wait does not outlast the
+// connection's MaxConnLifetime.
+func (s *Server) awaitNextRequest(conn net.Conn, reader *bufio.Reader, opened time.Time) bool {
+	if reader.Buffered() > 0 {
+		return true
+	}
+
+	deadline := time.Now().Add(s.IdleTimeout)
+	if s.MaxConnLifetime > 0 {
+		deadline = earliest(deadline, opened.Add(s.MaxConnLifetime))
+	}
+
+	// The deadline is set before the connection is marked idle so that
+	// closeIdleConns cutting it short is not overwritten.
+	conn.SetReadDeadline(deadline)
+	if !s.markIdle(conn) {
+		return false
+	}
+	defer s.markBusy(conn)
+
+	_, err := reader.Peek(1)
+	return err == nil
+}
+
+func (s *Server) markIdle(conn net.Conn) bool {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+	if s.idleConns == nil {
+		s.idleConns = make(map[net.Conn]time.Time)
+	}
+	s.idleConns[conn] = time.Now()
+	return true
+}
+
+func (s *Server) markBusy(conn net.Conn) {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	delete(s.idleConns, conn)
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	return s.shuttingDown
+}
+
+// evictIdleConn wakes the keep-alive connection that has been idle the
+// longest, so that it closes and gives up its connection slot. It reports
+// whether there was one.
+func (s *Server) evictIdleConn() bool {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	var oldest net.Conn
+	var oldestSince time.Time
+	for conn, since := range s.idleConns {
+		if oldest == nil || since.Before(oldestSince) {
+			oldest, oldestSince = conn, since
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	delete(s.idleConns, oldest)
+	oldest.SetReadDeadline(time.Now())
+	atomic.AddInt64(&s.Stats.EvictedConnections, 1)
+	s.Logger.Infof("Closing %s, idle since %s, to make room for a new connection", oldest.RemoteAddr(), oldestSince.Format("15:04:05"))
+	return true
+}
+
+// closeIdleConns stops keep-alive: responses from now on carry
+// Connection: close and connections waiting for their next request are
+// woken so they close instead of holding up shutdown.
+func (s *Server) closeIdleConns() {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	s.shuttingDown = true
+	for conn := range s.idleConns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
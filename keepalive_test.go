@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseAfterShutdownStartsClosesConnection(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("GET", "/inflight", func(*HTTPRequest) *HTTPResponse {
+		// Shutdown begins while the handler is still running.
+		s.closeIdleConns()
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("done")}
+	})
+
+	response := exchange(t, s, "GET /inflight HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+		t.Fatalf("status = %q, want 200", got)
+	}
+	if !strings.Contains(response, "Connection: close\r\n") || strings.Contains(response, "Keep-Alive:") {
+		t.Errorf("in-flight response during shutdown offered keep-alive:\n%s", response)
+	}
+}
+
+// shutdownAfterWrite starts shutting s down once the first response has
+// gone out, before the connection looks for the next request.
+type shutdownAfterWrite struct {
+	net.Conn
+	s    *Server
+	once sync.Once
+}
+
+func (c *shutdownAfterWrite) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.once.Do(c.s.closeIdleConns)
+	return n, err
+}
+
+func TestPipelinedRequestIsAnsweredDuringShutdown(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("GET", "/a", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("a")}
+	})
+	s.Handle("GET", "/b", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("b")}
+	})
+
+	client, server := net.Pipe()
+	go s.handleConnection(&shutdownAfterWrite{Conn: server, s: s})
+	response := roundTrip(t, client, "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\nGET /b HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	if n := strings.Count(response, "HTTP/1.1 200 OK\r\n"); n != 2 {
+		t.Fatalf("got %d responses, want 2:\n%s", n, response)
+	}
+	_, second, _ := strings.Cut(response, "\r\n\r\na")
+	if !strings.Contains(second, "Connection: close\r\n") || !strings.HasSuffix(second, "\r\n\r\nb") {
+		t.Errorf("second response = %q, want /b with Connection: close", second)
+	}
+}
+
+// readResponse reads one response, body included, from a connection the
+// test keeps open.
+func readResponse(t *testing.T, reader *bufio.Reader) *http.Response {
+	t.Helper()
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// dialPipe serves one end of a pipe with s and returns the other end
+// with a reader for the responses.
+func dialPipe(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.handleConnection(server)
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client, bufio.NewReader(client)
+}
+
+func TestMaxConnLifetime(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxConnLifetime = 200 * time.Millisecond
+	s.Handle("GET", "/slow", func(*HTTPRequest) *HTTPResponse {
+		time.Sleep(s.MaxConnLifetime)
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("done")}
+	})
+	client, reader := dialPipe(t, s)
+
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if resp := readResponse(t, reader); resp.Close {
+		t.Fatalf("young connection got Connection: close")
+	}
+
+	// The connection outlives its lifetime while this request runs.
+	io.WriteString(client, "GET /slow HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	if resp := readResponse(t, reader); !resp.Close {
+		t.Errorf("response finished past MaxConnLifetime kept the connection alive")
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after its lifetime: %v", err)
+	}
+}
+
+func TestPipelinedRequestsAreAnsweredInOrder(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		s.Handle("GET", "/"+name, func(*HTTPRequest) *HTTPResponse {
+			return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte(name)}
+		})
+	}
+	client, reader := dialPipe(t, s)
+
+	// All three requests arrive before the first is answered.
+	go io.WriteString(client, "GET /a HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"GET /b HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"GET /c HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+
+	for i, want := range []string{"a", "b", "c"} {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want {
+			t.Errorf("response %d = %q, want %q", i+1, body, want)
+		}
+		if last := i == 2; resp.Close != last {
+			t.Errorf("response %d: Connection: close = %v, want %v", i+1, resp.Close, last)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection open after Connection: close: %v", err)
+	}
+}
+
+func TestHTTP10KeepsAliveOnlyOnRequest(t *testing.T) {
+	s := newTestServer(t)
+
+	client, reader := dialPipe(t, s)
+	go io.WriteString(client, "GET / HTTP/1.0\r\n\r\n")
+	if resp := readResponse(t, reader); !resp.Close {
+		t.Errorf("HTTP/1.0 without keep-alive: connection kept open")
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("HTTP/1.0 connection not closed: %v", err)
+	}
+
+	client, reader = dialPipe(t, s)
+	for i := 0; i < 2; i++ {
+		go io.WriteString(client, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+		resp := readResponse(t, reader)
+		if resp.Close || resp.Header.Get("Connection") != "keep-alive" {
+			t.Fatalf("HTTP/1.0 request %d with keep-alive: Connection = %q", i+1, resp.Header.Get("Connection"))
+		}
+	}
+}
+
+func TestKeepAliveMaxCountsDown(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxKeepAliveRequests = 3
+	s.IdleTimeout = 5 * time.Second
+	client, reader := dialPipe(t, s)
+
+	for _, want := range []string{"timeout=5, max=2", "timeout=5, max=1", ""} {
+		go io.WriteString(client, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp := readResponse(t, reader)
+		if got := resp.Header.Get("Keep-Alive"); got != want {
+			t.Errorf("Keep-Alive = %q, want %q", got, want)
+		}
+		if resp.Close != (want == "") {
+			t.Errorf("with Keep-Alive %q: Connection: close = %v", want, resp.Close)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection open after MaxKeepAliveRequests: %v", err)
+	}
+}
+
+func TestIdleKeepAliveConnectionIsClosed(t *testing.T) {
+	s := newTestServer(t)
+	s.IdleTimeout = 100 * time.Millisecond
+	client, reader := dialPipe(t, s)
+
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp := readResponse(t, reader); resp.Close {
+		t.Fatalf("first response closed the connection")
+	}
+
+	start := time.Now()
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("idle connection: %v, want EOF", err)
+	}
+	if idle := time.Since(start); idle < s.IdleTimeout/2 || idle > 20*s.IdleTimeout {
+		t.Errorf("idle connection closed after %v, IdleTimeout is %v", idle, s.IdleTimeout)
+	}
+}
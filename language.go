@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLanguageVariantFormat = "index.%s.html"
+
+type languagePreference struct {
+	tag string
+	q   float64
+}
+
+// resolveIndex picks the index file for a directory. With language
+// negotiation enabled it looks for variants such as index.fr.html in the
+// client's Accept-Language order, then the default language, and finally
+// falls back to the plain index.html.
+func (s *Server) resolveIndex(dir string, request *HTTPRequest) (string, string) {
+	index := filepath.Join(dir, "index.html")
+	if !s.LanguageNegotiation {
+		return index, ""
+	}
+
+	format := s.LanguageVariantFormat
+	if format == "" {
+		format = DefaultLanguageVariantFormat
+	}
+
+	languages := parseAcceptLanguage(request.Headers["accept-language"])
+	if s.DefaultLanguage != "" {
+		languages = append(languages, strings.ToLower(s.DefaultLanguage))
+	}
+
+	for _, lang := range languages {
+		candidate := filepath.Join(dir, fmt.Sprintf(format, lang))
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, lang
+		}
+	}
+
+	return index, ""
+}
+
+func parseAcceptLanguage(header string) []string {
+	var prefs []languagePreference
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !isLanguageTag(tag) {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+
+		prefs = append(prefs, languagePreference{tag: tag, q: q})
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool {
+		return prefs[i].q > prefs[j].q
+	})
+
+	var languages []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			languages = append(languages, tag)
+		}
+	}
+
+	for _, pref := range prefs {
+		add(pref.tag)
+		if primary, _, ok := strings.Cut(pref.tag, "-"); ok {
+			add(primary)
+		}
+	}
+
+	return languages
+}
+
+// isLanguageTag rejects anything but letters, digits and hyphens so that a
+// tag can be safely substituted into a file name.
+func isLanguageTag(tag string) bool {
+	if tag == "" || len(tag) > 35 {
+		return false
+	}
+
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
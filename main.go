@@ -2,165 +2,981 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
 	"fmt"
-	_ "io"
+	"html"
+	"io"
+	"io/fs"
 	"log"
+	"math"
 	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/textproto"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
-	_ "strconv"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	DefaultPort     = "8080"
-	DocumentRoot    = "./www"
-	ServerName      = "SimpleHTTP/1.0"
-	MaxRequestSize  = 8192
-	ReadTimeout     = 30 * time.Second
-	WriteTimeout    = 30 * time.Second
+	DefaultPort          = "8080"
+	DocumentRoot         = "./www"
+	ServerName           = "SimpleHTTP/1.0"
+	MaxRequestSize       = 8192
+	MaxHeaderLines       = 100
+	DefaultHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout   = 30 * time.Second
+	DefaultWriteTimeout  = 30 * time.Second
+	DefaultIdleTimeout   = 5 * time.Second
+	DefaultMaxKeepAlive  = 100
+	DefaultMaxBodySize   = 1 << 20
+	ShutdownGracePeriod  = 10 * time.Second
+	DefaultRetryAfter    = 5 * time.Second
+	WriteChunkSize       = 32 * 1024
+	RateWindowSeconds    = 10
 )
 
 const (
-	StatusOK                  = "200 OK"
-	StatusNotFound           = "404 Not Found"
-	StatusMethodNotAllowed   = "405 Method Not Allowed"
-	StatusInternalServerError = "500 Internal Server Error"
-	StatusBadRequest         = "400 Bad Request"
+	StatusOK                      = 200
+	StatusPartialContent          = 206
+	StatusMovedPermanently        = 301
+	StatusNotModified             = 304
+	StatusBadRequest              = 400
+	StatusUnauthorized            = 401
+	StatusForbidden               = 403
+	StatusNotFound                = 404
+	StatusMethodNotAllowed        = 405
+	StatusRequestTimeout          = 408
+	StatusLengthRequired          = 411
+	StatusPayloadTooLarge         = 413
+	StatusRangeNotSatisfiable     = 416
+	StatusHeaderFieldsTooLarge    = 431
+	StatusInternalServerError     = 500
+	StatusServiceUnavailable      = 503
+	StatusHTTPVersionNotSupported = 505
 )
 
-var mimeTypes = map[string]string{
-	".html": "text/html",
-	".htm":  "text/html",
-	".css":  "text/css",
-	".js":   "application/javascript",
-	".json": "application/json",
-	".png":  "image/png",
-	".jpg":  "image/jpeg",
-	".jpeg": "image/jpeg",
-	".gif":  "image/gif",
-	".ico":  "image/x-icon",
-	".txt":  "text/plain",
-	".pdf":  "application/pdf",
-	".zip":  "application/zip",
+// reasonPhrases are the reason phrases that differ from http.StatusText,
+// which still uses the older RFC 2616 wording for these.
+var reasonPhrases = map[int]string{
+	StatusPayloadTooLarge:     "Payload Too Large",
+	StatusRangeNotSatisfiable: "Range Not Satisfiable",
 }
 
+func statusText(code int) string {
+	if text, ok := reasonPhrases[code]; ok {
+		return text
+	}
+	return http.StatusText(code)
+}
+
+const (
+	ErrorVerbosityMinimal  = "minimal"
+	ErrorVerbosityStandard = "standard"
+	ErrorVerbosityDebug    = "debug"
+)
+
+const HeadersFileName = "_headers"
+
+var reservedHeaders = map[string]bool{
+	"content-length":    true,
+	"content-type":      true,
+	"connection":        true,
+	"transfer-encoding": true,
+	"date":              true,
+	"server":            true,
+}
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const HTTP09 = "HTTP/0.9"
+
+type Logger interface {
+	Infof(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+type stdLogger struct {
+	*log.Logger
+}
+
+func (l stdLogger) Infof(format string, args ...any) {
+	l.Printf(format, args...)
+}
+
+func (l stdLogger) Errorf(format string, args ...any) {
+	l.Printf(format, args...)
+}
+
+// ServerStats counters are updated from many connections at once; access
+// them only through sync/atomic.
 type ServerStats struct {
-	TotalRequests     int64
+	TotalRequests      int64
 	SuccessfulRequests int64
-	ErrorRequests     int64
-	StartTime         time.Time
+	ErrorRequests      int64
+	StartTime          time.Time
+
+	OpenConnections     int64
+	InFlightRequests    int64
+	RejectedConnections int64
+	EvictedConnections  int64
+	requestRate         uint64
+
+	routesMu sync.Mutex
+	routes   map[string]*RouteStats
+}
+
+func (st *ServerStats) RequestsPerSecond() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&st.requestRate))
 }
 
 type HTTPRequest struct {
-	Method  string
-	Path    string
-	Version string
-	Headers map[string]string
+	Method     string
+	Path       string
+	Version    string
+	Headers    map[string]string
+	RemoteAddr string
+
+	// Body holds the Content-Length bytes sent with a POST, PUT or PATCH.
+	// BodyReader yields the same bytes; a body over the server's
+	// MaxBufferedBody is not read in advance, leaving Body nil and
+	// BodyReader reading it from the connection.
+	Body       []byte
+	BodyReader io.Reader
+
+	// ClientIP, Scheme and Host describe the original client request,
+	// which differs from the TCP peer behind a trusted proxy.
+	ClientIP string
+	Scheme   string
+	Host     string
+
+	// TLS is the negotiated connection state, nil on plain connections.
+	// ClientSubject is the subject of the client certificate verified
+	// against ClientCAFile, empty without one.
+	TLS           *tls.ConnectionState
+	ClientSubject string
+
+	ctx context.Context
+	// served is how many requests the connection carried before this one,
+	// and opened is when the connection was accepted.
+	served int
+	opened time.Time
+	// bodySize is the Content-Length of the body, buffered or streamed.
+	bodySize int64
+}
+
+func (r *HTTPRequest) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// SetValue attaches a request-scoped value for downstream middleware and
+// handlers. As with context.WithValue, keys should be of an unexported type
+// owned by the package that sets them.
+func (r *HTTPRequest) SetValue(key, value any) {
+	r.ctx = context.WithValue(r.Context(), key, value)
+}
+
+func (r *HTTPRequest) Value(key any) any {
+	return r.Context().Value(key)
 }
 
 type HTTPResponse struct {
-	Status      string
+	// Code is the numeric status; Reason, if set, replaces the standard
+	// reason phrase in the status line.
+	Code        int
+	Reason      string
 	Headers     map[string]string
 	Body        []byte
 	ContentType string
+
+	// BodyReader, when set, is streamed after the headers instead of Body,
+	// and BodySize is its length. It is closed once the response has been
+	// sent if it is an io.Closer.
+	BodyReader io.Reader
+	BodySize   int64
+
+	// errorMessage is the human-readable message of an error page, kept
+	// so the page can be re-rendered in another format.
+	errorMessage string
+
+	// route is the path of the route or registered static response that
+	// produced the response, for the per-route stats.
+	route string
+
+	keepAlive bool
+	served    int
+	opened    time.Time
+	// omitBody answers HEAD: headers, including Content-Length, are sent
+	// as for GET but the body is not.
+	omitBody bool
 }
 
 type Server struct {
-	Port     string
-	Root     string
-	Stats    *ServerStats
-	listener net.Listener
+	Port        string
+	Root        string
+	RootLayers  []string
+	Stats       *ServerStats
+	GracePeriod time.Duration
+	AdminAddr   string
+	Logger      Logger
+	ReusePort   bool
+	DualStack   bool
+
+	StrictLineEndings bool
+	LenientMethodCase bool
+	AllowHTTP09       bool
+	DisableDateHeader bool
+	Now               func() time.Time
+	H2CHandler        func(conn net.Conn, reader *bufio.Reader)
+	AccessLogExclude  []string
+	AccessLog         io.Writer
+	AccessLogFormat   string
+	RequireHost       bool
+	ErrorVerbosity    string
+	ProblemDetails    bool
+
+	WriteProgressTimeout time.Duration
+	RedirectNonCanonical bool
+	RequestTimeout       time.Duration
+
+	// IdleTimeout is how long a keep-alive connection may sit between
+	// requests; zero disables keep-alive. MaxKeepAliveRequests caps the
+	// requests served on one connection, zero meaning no cap.
+	// MaxConnLifetime closes a connection, after answering the request in
+	// progress, once it has been open that long however busy it is; zero
+	// means no limit.
+	IdleTimeout          time.Duration
+	MaxKeepAliveRequests int
+	MaxConnLifetime      time.Duration
+
+	// HeaderTimeout bounds reading the request line and headers,
+	// ReadTimeout the whole request including its body, and WriteTimeout
+	// the response. Zero disables a timeout.
+	HeaderTimeout time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+
+	// ErrorPages maps a status code to a page under the site, such as
+	// "/errors/404.html", sent instead of the built-in error page.
+	ErrorPages map[int]string
+
+	// FS, when set, is served instead of Root, RootLayers and virtual
+	// hosts; see NewFSServer.
+	FS fs.FS
+
+	// MaxBodySize is the largest request body accepted, in bytes. Bodies
+	// over MaxBufferedBody are streamed to the handler through
+	// HTTPRequest.BodyReader rather than read into memory; zero buffers
+	// every body.
+	MaxBodySize     int64
+	MaxBufferedBody int64
+
+	// MaxConnections caps the connections served at once; zero means no
+	// cap. Connections beyond it are answered 503 and closed. With
+	// EvictIdle, a new connection first gets the slot of the keep-alive
+	// connection that has been idle longest.
+	MaxConnections int
+	EvictIdle      bool
+
+	// BusyBody replaces the page in the 503 written to connections turned
+	// away at MaxConnections, with BusyContentType (plain text if empty).
+	// Otherwise BusyPage, a page under the site like the ErrorPages ones,
+	// is used if set. BusyRetryAfter overrides RetryAfter for this 503.
+	// The response is built once at start and sent without reading the
+	// request.
+	BusyBody        []byte
+	BusyContentType string
+	BusyPage        string
+	BusyRetryAfter  time.Duration
+
+	PoweredBy        string
+	AltSvc           string
+	BaseHrefRules    []BaseHrefRule
+	DeprecationRules []DeprecationRule
+
+	// AuthRules password-protect path prefixes with Basic authentication.
+	AuthRules []AuthRule
+
+	// TestMode lets clients request an artificial delay (?__delay=500ms)
+	// or status code (?__status=503). It is meant for QA against a real
+	// server and must never be enabled on a public deployment: anyone
+	// allowed by TestModeClients can make the server stall or fail on
+	// demand. With TestModeClients empty only loopback clients qualify.
+	TestMode        bool
+	TestModeClients []string
+
+	// TrustedProxies lists the IPs/CIDRs whose Forwarded and
+	// X-Forwarded-* headers are believed.
+	TrustedProxies []string
+
+	LanguageNegotiation   bool
+	DefaultLanguage       string
+	LanguageVariantFormat string
+
+	ACMEDomains  []string
+	ACMECacheDir string
+
+	// CertFile and KeyFile enable HTTPS, on TLSPort if set and otherwise
+	// on Port. RedirectHTTPS sends plain requests to TLSPort with a 301.
+	// ClientCAFile, a PEM bundle, makes HTTPS clients present a
+	// certificate signed by one of its CAs; the handshake fails without.
+	CertFile      string
+	KeyFile       string
+	TLSPort       string
+	RedirectHTTPS bool
+	ClientCAFile  string
+
+	SitemapBaseURL  string
+	SitemapInterval time.Duration
+
+	// SpecialFileStatus is returned for FIFOs, sockets, devices and other
+	// non-regular files found under the root; StatusNotFound by default.
+	SpecialFileStatus int
+
+	// ContentETags derives file ETags from a SHA-256 of the contents
+	// rather than the mtime and size, for deploys (rsync, container
+	// layers) that change timestamps without changing files. Each hash is
+	// cached until the file's mtime or size changes.
+	ContentETags bool
+
+	// EnableDirListing renders an index of directories that have no
+	// index.html.
+	EnableDirListing bool
+
+	// Compress gzips compressible responses for clients that accept it,
+	// at CompressionLevel: gzip.BestSpeed (1) through gzip.BestCompression
+	// (9), or gzip.DefaultCompression (-1).
+	Compress         bool
+	CompressionLevel int
+
+	// LogTimings appends a parse/handle/write latency breakdown to each
+	// access log line.
+	LogTimings bool
+	LogTLS     bool
+
+	// ShutdownOnStdinClose shuts the server down gracefully, as on SIGTERM,
+	// once standard input reaches EOF. For supervisors that stop a child
+	// by closing its stdin instead of signalling it.
+	ShutdownOnStdinClose bool
+
+	MaintenanceWindows []MaintenanceWindow
+
+	// RetryAfter is the Retry-After sent with the 503 for a connection
+	// over MaxConnections, unless BusyRetryAfter is set, and during
+	// maintenance outside a scheduled window, whose end is announced
+	// instead.
+	RetryAfter time.Duration
+
+	// VersionPath and StatsPath serve the build info and the current
+	// stats as JSON when set; such requests are not counted in the stats.
+	VersionPath         string
+	StatsPath           string
+	ServerHeaderVersion bool
+
+	// ExtensionlessType is the Content-Type for files with no extension,
+	// such as README or LICENSE; unset, they get application/octet-stream
+	// like any unknown type.
+	ExtensionlessType string
+
+	// CSPTemplate is sent as the Content-Security-Policy header with every
+	// {nonce} replaced by a fresh per-request nonce, e.g.
+	// "script-src 'nonce-{nonce}'". Registered static responses are
+	// prebuilt and do not get it.
+	CSPTemplate string
+
+	listeners  []net.Listener
+	admin      *http.Server
+	acme       *http.Server
+	conns      sync.WaitGroup
+	started    int32
+	startMu    sync.Mutex
+	serving    bool
+	acceptDone chan struct{}
+	stopped    chan struct{}
+	archive    *siteArchive
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+	activeMu     sync.Mutex
+	activeConns  map[net.Conn]struct{}
+
+	// maintenance is the mode set with SetMaintenance and
+	// scheduledMaintenance the one set by MaintenanceWindows; either
+	// puts the server in maintenance.
+	maintenance          int32
+	scheduledMaintenance int32
+
+	idleMu       sync.Mutex
+	idleConns    map[net.Conn]time.Time
+	shuttingDown bool
+
+	headersMu    sync.Mutex
+	headersCache map[string]*dirHeaders
+
+	etagMu    sync.Mutex
+	etagCache map[string]*contentTag
+
+	staticMu        sync.RWMutex
+	staticResponses map[string]*staticResponse
+
+	connSlots    chan struct{}
+	busyResponse *staticResponse
+	accessLogMu  sync.Mutex
+	vhostMu      sync.RWMutex
+	vhosts       map[string]string
+	mimeMu       sync.RWMutex
+	mimeTypes    map[string]string
+	routesMu     sync.RWMutex
+	routes       map[routeKey][]route
+	middlewareMu sync.RWMutex
+	middleware   []Middleware
+
+	sitemapMu sync.Mutex
+	sitemaps  map[string]*cachedSitemap
+
+	errorPageMu     sync.Mutex
+	errorPageLogged map[string]bool
+}
+
+type dirHeaders struct {
+	modTime time.Time
+	headers map[string]string
 }
 
 func NewServer(port, root string) *Server {
 	return &Server{
-		Port:  port,
-		Root:  root,
-		Stats: &ServerStats{StartTime: time.Now()},
+		Port:                 port,
+		Root:                 root,
+		Stats:                &ServerStats{StartTime: time.Now()},
+		GracePeriod:          ShutdownGracePeriod,
+		MaxKeepAliveRequests: DefaultMaxKeepAlive,
+		MaxBodySize:          DefaultMaxBodySize,
+		Logger:               stdLogger{log.Default()},
+		RequireHost:          true,
+		IdleTimeout:          DefaultIdleTimeout,
+		HeaderTimeout:        DefaultHeaderTimeout,
+		ReadTimeout:          DefaultReadTimeout,
+		WriteTimeout:         DefaultWriteTimeout,
+		Compress:             true,
+		CompressionLevel:     gzip.DefaultCompression,
+		RetryAfter:           DefaultRetryAfter,
+		EvictIdle:            true,
+		acceptDone:           make(chan struct{}),
+		stopped:              make(chan struct{}),
 	}
 }
 
-func (s *Server) Start() error {
-	var err error
-	s.listener, err = net.Listen("tcp", ":"+s.Port)
+var errAlreadyStarted = errors.New("server already started")
+
+// Start serves until ctx is cancelled or Shutdown is called, then shuts
+// down gracefully within GracePeriod and returns. It returns nil after a
+// clean shutdown and Shutdown's error when connections were abandoned.
+// A Server can be started once.
+func (s *Server) Start(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		return errAlreadyStarted
+	}
+
+	// Shutdown waits for the set-up to finish, so it sees every listener
+	// and knows whether there is an accept loop to wait for.
+	s.startMu.Lock()
+	err := s.setUp(ctx)
+	s.serving = err == nil
+	s.startMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var accepting sync.WaitGroup
+	for _, listener := range s.listeners {
+		accepting.Add(1)
+		go func(listener net.Listener) {
+			defer accepting.Done()
+			s.acceptLoop(listener)
+		}(listener)
+	}
+	accepting.Wait()
+
+	close(s.acceptDone)
+	<-s.stopped
+	return s.shutdownErr
+}
+
+// setUp opens the listeners and starts the helper servers and
+// goroutines. It leaves nothing listening when it fails.
+func (s *Server) setUp(ctx context.Context) error {
+	if err := s.checkCompressionLevel(); err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	if s.ReusePort {
+		lc.Control = setReusePort
+	}
+
+	if s.MaxConnections > 0 {
+		s.connSlots = make(chan struct{}, s.MaxConnections)
+		s.busyResponse = s.newBusyResponse()
+	}
+
+	listeners, err := s.listen(lc, s.Port)
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %s: %v", s.Port, err)
+		return err
+	}
+	s.listeners = listeners
+
+	if s.CertFile != "" || s.KeyFile != "" || s.RedirectHTTPS || s.ClientCAFile != "" {
+		if err := s.startTLS(lc); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	if len(s.ACMEDomains) > 0 {
+		if err := s.startACME(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+
+	s.Logger.Infof("SimpleHTTP Server started on port %s", s.Port)
+	switch {
+	case s.archive != nil:
+		s.Logger.Infof("Serving from archive: %s (%d files)", s.Root, len(s.archive.files))
+	case s.FS != nil:
+		s.Logger.Infof("Serving from an in-process filesystem")
+	case len(s.RootLayers) > 0:
+		s.Logger.Infof("Document root layers: %s", strings.Join(s.RootLayers, ", "))
+	default:
+		s.Logger.Infof("Document root: %s", s.Root)
+	}
+	for host, root := range s.vhosts {
+		s.Logger.Infof("Virtual host %s: %s", host, root)
 	}
+	s.Logger.Infof("Press Ctrl+C to stop")
 
-	log.Printf("SimpleHTTP Server started on port %s", s.Port)
-	log.Printf("Document root: %s", s.Root)
-	log.Println("Press Ctrl+C to stop")
+	if s.archive == nil && s.FS == nil && s.Root != "" {
+		if err := os.MkdirAll(s.Root, 0755); err != nil {
+			s.Logger.Errorf("Warning: Could not create document root: %v", err)
+		}
+	}
+
+	if s.TestMode {
+		s.Logger.Errorf("WARNING: test mode is enabled, trusted clients can force delays and error statuses")
+	}
+
+	if s.AdminAddr != "" {
+		if err := s.startAdmin(); err != nil {
+			s.closeListeners()
+			if s.acme != nil {
+				s.acme.Close()
+			}
+			return err
+		}
+	}
+
+	go s.handleShutdown(ctx)
+	go s.sampleRequestRate()
+	if len(s.MaintenanceWindows) > 0 {
+		go s.runMaintenanceScheduler()
+	}
+	return nil
+}
 
-	if err := os.MkdirAll(s.Root, 0755); err != nil {
-		log.Printf("Warning: Could not create document root: %v", err)
+// listen opens the listeners for port: one, or an IPv4 and an IPv6 one
+// when DualStack is set.
+func (s *Server) listen(lc net.ListenConfig, port string) ([]net.Listener, error) {
+	addresses := map[string]string{"tcp": ":" + port}
+	networks := []string{"tcp"}
+	if s.DualStack {
+		addresses = map[string]string{"tcp4": "0.0.0.0:" + port, "tcp6": "[::]:" + port}
+		networks = []string{"tcp4", "tcp6"}
 	}
 
-	go s.handleShutdown()
+	var listeners []net.Listener
+	for _, network := range networks {
+		listener, err := lc.Listen(context.Background(), network, addresses[network])
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %v", addresses[network], err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
 
+func (s *Server) acceptLoop(listener net.Listener) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if strings.Contains(err.Error(), "use of closed network connection") {
-				break
+				return
 			}
-			log.Printf("Error accepting connection: %v", err)
+			s.Logger.Errorf("Error accepting connection: %v", err)
 			continue
 		}
 
-		go s.handleConnection(conn)
+		// Waiting for a slot and turning the connection away both happen
+		// off the accept loop, so an overload does not slow accepting.
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			if !s.acquireSlot() {
+				s.rejectConnection(conn)
+				return
+			}
+			defer s.releaseSlot()
+
+			atomic.AddInt64(&s.Stats.OpenConnections, 1)
+			defer atomic.AddInt64(&s.Stats.OpenConnections, -1)
+			s.trackConn(conn, true)
+			defer s.trackConn(conn, false)
+			s.handleConnection(conn)
+		}()
 	}
+}
 
-	return nil
+func (s *Server) closeListeners() {
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	conn.SetReadDeadline(time.Now().Add(ReadTimeout))
-	conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	s.Logger.Infof("Connection from %s", conn.RemoteAddr())
+
+	opened := time.Now()
+	reader := bufio.NewReader(conn)
+	for served := 0; ; served++ {
+		if served > 0 && !s.awaitNextRequest(conn, reader, opened) {
+			return
+		}
+		if !s.serveRequest(conn, reader, served, opened) {
+			return
+		}
+	}
+}
+
+// serveRequest reads and answers one request on conn. served is how many
+// requests the connection has already carried since it was opened. It
+// reports whether the connection should be kept open for another request.
+func (s *Server) serveRequest(conn net.Conn, reader *bufio.Reader, served int, opened time.Time) bool {
+	readDeadline := after(s.ReadTimeout)
+	writeDeadline := after(s.WriteTimeout)
+
+	ctx := context.Background()
+	if s.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+
+		deadline, _ := ctx.Deadline()
+		readDeadline = earliest(readDeadline, deadline)
+		writeDeadline = earliest(writeDeadline, deadline)
+	}
+
+	conn.SetReadDeadline(earliest(readDeadline, after(s.HeaderTimeout)))
+	conn.SetWriteDeadline(writeDeadline)
+
+	var timings *requestTimings
+	if s.LogTimings {
+		timings = &requestTimings{last: time.Now()}
+	}
+
+	// A client that connects and closes without sending a byte, such as a
+	// health probe, made no request to answer or count.
+	if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+		return false
+	}
 
-	log.Printf("Connection from %s", conn.RemoteAddr())
+	if served == 0 && isHTTP2Preface(reader) {
+		s.handleHTTP2Preface(conn, reader)
+		return false
+	}
 
-	request, err := s.parseRequest(conn)
+	request, err := s.parseRequest(reader)
+	if err == nil {
+		conn.SetReadDeadline(readDeadline)
+		err = s.readBody(reader, request)
+	}
 	if err != nil {
-		s.sendErrorResponse(conn, StatusBadRequest, "Bad Request")
-		s.Stats.ErrorRequests++
-		log.Printf("Error parsing request: %v", err)
-		return
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// The write deadline was armed together with the read deadline
+			// and may have expired as well.
+			conn.SetWriteDeadline(after(s.WriteTimeout))
+			s.sendErrorResponse(conn, StatusRequestTimeout, "Request Timeout", err)
+		} else if errors.Is(err, errLengthRequired) {
+			s.sendErrorResponse(conn, StatusLengthRequired, "Length Required", err)
+		} else if errors.Is(err, errPayloadTooLarge) {
+			s.sendErrorResponse(conn, StatusPayloadTooLarge, "Payload Too Large", err)
+		} else if errors.Is(err, errHeadersTooLarge) {
+			s.sendErrorResponse(conn, StatusHeaderFieldsTooLarge, "Request Header Fields Too Large", err)
+		} else {
+			s.sendErrorResponse(conn, StatusBadRequest, "Bad Request", err)
+		}
+		atomic.AddInt64(&s.Stats.ErrorRequests, 1)
+		s.Logger.Errorf("Error parsing request: %v", err)
+		return false
 	}
 
-	s.Stats.TotalRequests++
+	request.RemoteAddr = conn.RemoteAddr().String()
+	request.ctx = ctx
+	request.served = served
+	request.opened = opened
+	s.resolveClient(conn, request)
+	keepAlive := s.wantsKeepAlive(request, served, opened)
+
+	if response := s.internalResponse(request); response != nil {
+		s.applyErrorPage(request, response)
+		response.keepAlive = keepAlive
+		response.served = served
+		response.opened = opened
+		response.omitBody = request.Method == "HEAD"
+		var err error
+		if request.Version == HTTP09 {
+			// As in respond: the bare body, after which the connection
+			// closes.
+			response.keepAlive = false
+			err = writeBody(conn, response)
+			response.closeBody()
+		} else {
+			err = s.sendResponse(conn, response)
+		}
+		if err != nil {
+			s.Logger.Errorf("Error sending response: %v", err)
+			return false
+		}
+		return response.keepAlive
+	}
 
-	response := s.handleRequest(request)
+	atomic.AddInt64(&s.Stats.TotalRequests, 1)
+	timings.parsed()
 
-	if err := s.sendResponse(conn, response); err != nil {
-		log.Printf("Error sending response: %v", err)
-		s.Stats.ErrorRequests++
-		return
+	if s.CSPTemplate != "" {
+		if err := s.assignCSPNonce(request); err != nil {
+			s.Logger.Errorf("Error generating CSP nonce: %v", err)
+			s.sendErrorResponse(conn, StatusInternalServerError, "Internal Server Error", err)
+			atomic.AddInt64(&s.Stats.ErrorRequests, 1)
+			return false
+		}
+	}
+
+	atomic.AddInt64(&s.Stats.InFlightRequests, 1)
+	defer atomic.AddInt64(&s.Stats.InFlightRequests, -1)
+
+	status, reason, sent, keepAlive, err := s.respond(ctx, conn, request, timings, keepAlive)
+	if err != nil {
+		s.Logger.Errorf("Error sending response to %s %s: %v", request.Method, request.Path, err)
+		atomic.AddInt64(&s.Stats.ErrorRequests, 1)
+		return false
 	}
 
-	if response.Status == StatusOK {
-		s.Stats.SuccessfulRequests++
+	if isSuccessStatus(status) {
+		atomic.AddInt64(&s.Stats.SuccessfulRequests, 1)
 	} else {
-		s.Stats.ErrorRequests++
+		atomic.AddInt64(&s.Stats.ErrorRequests, 1)
+	}
+
+	if !s.isLogExcluded(request.Path) {
+		s.logRequest(request, status, reason, timings)
+		s.writeAccessLog(request, status, sent)
 	}
 
-	s.logRequest(request, response.Status)
+	return keepAlive
 }
 
-func (s *Server) parseRequest(conn net.Conn) (*HTTPRequest, error) {
-	reader := bufio.NewReader(conn)
+// respond answers request and reports the status code and reason phrase
+// sent, the number of body bytes sent and whether the connection can stay
+// open, which it cannot if the handler overran the request timeout and is
+// still running.
+func (s *Server) respond(ctx context.Context, conn net.Conn, request *HTTPRequest, timings *requestTimings, keepAlive bool) (int, string, int, bool, error) {
+	simple := request.Version == HTTP09
+
+	// Middleware sees static responses too, so with any registered they
+	// are answered through handleRequest instead of the prebuilt bytes.
+	if static := s.lookupStaticResponse(request); static != nil && !simple && !s.hasMiddleware() && s.gateRequest(request) == nil {
+		timings.handled()
+		keepAlive = keepAlive && s.keepOpen(request.opened)
+		omitBody := request.Method == "HEAD"
+		err := s.sendStaticResponse(conn, static, keepAlive, request.served, omitBody)
+		timings.written()
+		sent := len(static.body)
+		if omitBody {
+			sent = 0
+		}
+		s.Stats.countRoute(static.path, request.bodySize, int64(sent))
+		return static.code, statusText(static.code), sent, keepAlive, err
+	}
+
+	response, out, completed := s.produceResponse(ctx, conn, request)
+	// An abandoned handler may still be reading the body.
+	response.keepAlive = keepAlive && completed && finishBody(request)
+	response.served = request.served
+	response.opened = request.opened
+	response.omitBody = request.Method == "HEAD"
+	s.applyErrorPage(request, response)
+	s.injectBaseHref(request, response)
+	s.applyProblemDetails(request, response)
+	s.applyCSP(request, response)
+	s.applyDeprecation(request, response)
+	if !simple {
+		s.gzipResponse(request, response)
+	}
+	timings.handled()
+
+	var err error
+	if simple {
+		// An HTTP/0.9 response is the bare body: no status line, no headers.
+		err = writeBody(out, response)
+		response.closeBody()
+	} else {
+		err = s.sendResponse(out, response)
+	}
+	timings.written()
 
-	requestLine, err := reader.ReadString('\n')
+	sent := int(response.contentLength())
+	if response.omitBody {
+		sent = 0
+	}
+	s.Stats.countRoute(response.route, request.bodySize, int64(sent))
+	return response.Code, response.reason(), sent, response.keepAlive, err
+}
+
+// produceResponse runs handleRequest, bounded by the request deadline if
+// there is one, and returns the connection the response should be
+// written to. completed is false when the handler was abandoned.
+func (s *Server) produceResponse(ctx context.Context, conn net.Conn, request *HTTPRequest) (response *HTTPResponse, out net.Conn, completed bool) {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline {
+		return s.dispatch(request), conn, true
+	}
+
+	result := make(chan *HTTPResponse, 1)
+	go func() {
+		result <- s.dispatch(request)
+	}()
+
+	select {
+	case response := <-result:
+		// Keep per-chunk deadline extensions from outliving the request.
+		return response, &deadlineConn{Conn: conn, limit: deadline}, true
+	case <-ctx.Done():
+		// Release a file the abandoned handler may still hand back.
+		go func() {
+			if late := <-result; late != nil {
+				late.closeBody()
+			}
+		}()
+		s.Logger.Errorf("Request %s %s exceeded the %v request timeout", request.Method, request.Path, s.RequestTimeout)
+		conn.SetWriteDeadline(after(s.WriteTimeout))
+		return s.createErrorResponse(StatusServiceUnavailable, "Service Unavailable"), conn, false
+	}
+}
+
+// requestTimings splits a request's latency into reading and parsing the
+// request, producing the response and writing it out. A nil
+// *requestTimings records nothing.
+type requestTimings struct {
+	last                 time.Time
+	parse, handle, write time.Duration
+}
+
+func (t *requestTimings) parsed() {
+	if t != nil {
+		t.mark(&t.parse)
+	}
+}
+
+func (t *requestTimings) handled() {
+	if t != nil {
+		t.mark(&t.handle)
+	}
+}
+
+func (t *requestTimings) written() {
+	if t != nil {
+		t.mark(&t.write)
+	}
+}
+
+func (t *requestTimings) mark(phase *time.Duration) {
+	now := time.Now()
+	*phase = now.Sub(t.last)
+	t.last = now
+}
+
+func (t *requestTimings) String() string {
+	return fmt.Sprintf("parse=%v handle=%v write=%v total=%v",
+		t.parse, t.handle, t.write, t.parse+t.handle+t.write)
+}
+
+type deadlineConn struct {
+	net.Conn
+	limit time.Time
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(earliest(t, c.limit))
+}
+
+// earliest returns the sooner of two deadlines, where the zero time
+// means no deadline.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// after returns the deadline d from now, or no deadline if d is zero.
+func after(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+func (s *Server) parseRequest(reader *bufio.Reader) (*HTTPRequest, error) {
+	// The request line and headers share one MaxRequestSize budget.
+	remaining := MaxRequestSize
+	requestLine, err := s.readLine(reader, &remaining)
 	if err != nil {
-		return nil, fmt.Errorf("error reading request line: %v", err)
+		return nil, fmt.Errorf("error reading request line: %w", err)
 	}
 
-	parts := strings.Fields(strings.TrimSpace(requestLine))
+	parts := strings.Fields(requestLine)
+	if len(parts) == 2 && hasEmptyTarget(requestLine, parts) {
+		parts = []string{parts[0], "/", parts[1]}
+	}
+	if len(parts) == 2 && s.AllowHTTP09 && parts[0] == "GET" && strings.HasPrefix(parts[1], "/") {
+		// HTTP/0.9 simple request: no version and no headers follow.
+		return &HTTPRequest{
+			Method:  parts[0],
+			Path:    parts[1],
+			Version: HTTP09,
+			Headers: make(map[string]string),
+		}, nil
+	}
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("invalid request line format")
 	}
@@ -172,15 +988,25 @@ func (s *Server) parseRequest(conn net.Conn) (*HTTPRequest, error) {
 		Headers: make(map[string]string),
 	}
 
-	for {
-		line, err := reader.ReadString('\n')
+	// Methods are case-sensitive; "get" is not GET unless leniency is on.
+	if method := strings.ToUpper(request.Method); method != request.Method {
+		if !s.LenientMethodCase {
+			return nil, fmt.Errorf("method %q is not upper case", request.Method)
+		}
+		request.Method = method
+	}
+
+	for lines := 0; ; lines++ {
+		line, err := s.readLine(reader, &remaining)
 		if err != nil {
-			return nil, fmt.Errorf("error reading headers: %v", err)
+			return nil, fmt.Errorf("error reading headers: %w", err)
 		}
 
-		line = strings.TrimSpace(line)
 		if line == "" {
-			break 
+			break
+		}
+		if lines == MaxHeaderLines {
+			return nil, fmt.Errorf("%w: more than %d header lines", errHeadersTooLarge, MaxHeaderLines)
 		}
 
 		headerParts := strings.SplitN(line, ":", 2)
@@ -191,51 +1017,420 @@ func (s *Server) parseRequest(conn net.Conn) (*HTTPRequest, error) {
 		}
 	}
 
+	if _, ok := request.Headers["host"]; !ok && s.RequireHost && request.Version == "HTTP/1.1" {
+		return nil, fmt.Errorf("missing Host header")
+	}
+
+	if strings.EqualFold(request.Headers["upgrade"], "h2c") {
+		// Decline the upgrade and keep speaking HTTP/1.1.
+		delete(request.Headers, "upgrade")
+		delete(request.Headers, "http2-settings")
+	}
+
 	return request, nil
 }
 
+// hasEmptyTarget recognises "GET  HTTP/1.1", where the request target
+// between the two separators is empty, so it can be treated as "/". A
+// line with a single separator is still malformed.
+func hasEmptyTarget(requestLine string, parts []string) bool {
+	if !strings.HasPrefix(parts[1], "HTTP/") {
+		return false
+	}
+	gap := strings.TrimPrefix(strings.TrimSpace(requestLine), parts[0])
+	gap = strings.TrimSuffix(gap, parts[1])
+	return len(gap) >= 2
+}
+
+func isHTTP2Preface(reader *bufio.Reader) bool {
+	prefix, err := reader.Peek(3)
+	if err != nil || string(prefix) != "PRI" {
+		return false
+	}
+
+	preface, err := reader.Peek(len(http2Preface))
+	return err == nil && string(preface) == http2Preface
+}
+
+func (s *Server) handleHTTP2Preface(conn net.Conn, reader *bufio.Reader) {
+	if s.H2CHandler != nil {
+		conn.SetDeadline(time.Time{})
+		s.H2CHandler(conn, reader)
+		return
+	}
+
+	s.Logger.Infof("Rejecting HTTP/2 prior-knowledge connection from %s", conn.RemoteAddr())
+	s.sendErrorResponse(conn, StatusHTTPVersionNotSupported, "HTTP Version Not Supported", nil)
+	atomic.AddInt64(&s.Stats.ErrorRequests, 1)
+}
+
+func cleanPath(p string) string {
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+var errHeadersTooLarge = errors.New("request header too large")
+
+// readLine reads one line and charges it against remaining. It reads in
+// buffer-sized slices so a line that never ends costs at most the budget.
+func (s *Server) readLine(reader *bufio.Reader, remaining *int) (string, error) {
+	var buf []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(buf)+len(chunk) > *remaining {
+			return "", fmt.Errorf("%w: limit is %d bytes", errHeadersTooLarge, MaxRequestSize)
+		}
+		buf = append(buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	*remaining -= len(buf)
+	line := string(buf)
+
+	if strings.HasSuffix(line, "\r\n") {
+		line = line[:len(line)-2]
+	} else if s.StrictLineEndings {
+		return "", fmt.Errorf("bare LF line ending")
+	} else {
+		return line[:len(line)-1], nil
+	}
+
+	// A CR that is not part of a CRLF may be taken as a line break by
+	// another parser in the chain, which is how requests get smuggled.
+	if s.StrictLineEndings && strings.IndexByte(line, '\r') >= 0 {
+		return "", fmt.Errorf("bare CR in line")
+	}
+
+	return line, nil
+}
+
 func (s *Server) handleRequest(request *HTTPRequest) *HTTPResponse {
 
-	if request.Method != "GET" {
-		return s.createErrorResponse(StatusMethodNotAllowed, "Method Not Allowed")
+	// Static responses are health checks and stay up during maintenance.
+	static := s.lookupStaticResponse(request)
+	if s.InMaintenance() && static == nil {
+		return s.maintenanceResponse()
 	}
 
-	if !s.isSafePath(request.Path) {
-		return s.createErrorResponse(StatusNotFound, "Not Found")
+	if response := s.httpsRedirect(request); response != nil {
+		return response
 	}
 
-	filePath := filepath.Join(s.Root, request.Path)
+	rawPath, query, hasQuery := strings.Cut(request.Path, "?")
 
-	if strings.HasSuffix(request.Path, "/") {
-		filePath = filepath.Join(filePath, "index.html")
+	if canonical := cleanPath(rawPath); canonical != rawPath && s.RedirectNonCanonical {
+		location := canonical
+		if hasQuery {
+			location += "?" + query
+		}
+		return s.createRedirectResponse(StatusMovedPermanently, location)
+	}
+
+	// Decode before cleaning, so that an encoded %2e%2e is resolved as ".."
+	// and then checked for containment like any other path.
+	decodedPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return s.createErrorResponse(StatusBadRequest, "Bad Request")
+	}
+	requestPath := cleanPath(decodedPath)
+
+	if response := s.checkAuth(request, requestPath); response != nil {
+		return response
+	}
+
+	if static != nil {
+		response := static.response()
+		response.route = static.path
+		return response
 	}
 
-	fileInfo, err := os.Stat(filePath)
-	if err != nil || fileInfo.IsDir() {
+	if response := s.routeResponse(request, requestPath); response != nil {
+		return response
+	}
+
+	if request.Method != "GET" && request.Method != "HEAD" {
+		response := s.createErrorResponse(StatusMethodNotAllowed, "Method Not Allowed")
+		response.Headers["Allow"] = "GET, HEAD"
+		return response
+	}
+
+	if s.TestMode {
+		if response := s.applyTestMode(request, query); response != nil {
+			return response
+		}
+	}
+
+	if isAdminPath(requestPath) {
 		return s.createErrorResponse(StatusNotFound, "Not Found")
 	}
 
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	if path.Base(requestPath) == HeadersFileName {
+		return s.createErrorResponse(StatusNotFound, "Not Found")
 	}
 
-	return &HTTPResponse{
-		Status:      StatusOK,
+	if requestPath == SitemapPath && s.SitemapBaseURL != "" {
+		return s.serveSitemap(request)
+	}
+
+	if s.archive != nil {
+		return s.applyRange(request, s.serveArchive(request, requestPath))
+	}
+
+	if s.FS != nil {
+		return s.applyRange(request, s.serveFS(request, requestPath))
+	}
+
+	isDirRequest := strings.HasSuffix(requestPath, "/")
+
+	roots := s.rootsFor(request)
+	for _, root := range roots {
+		filePath, ok := resolvePath(root, requestPath)
+		if !ok {
+			return s.createErrorResponse(StatusNotFound, "Not Found")
+		}
+
+		var language string
+		if isDirRequest {
+			filePath, language = s.resolveIndex(filePath, request)
+		}
+
+		// Stat before opening: opening a FIFO without a writer blocks.
+		fileInfo, err := os.Stat(filePath)
+		if err != nil || fileInfo.IsDir() {
+			continue
+		}
+		if !fileInfo.Mode().IsRegular() {
+			return s.refuseSpecialFile(filePath, fileInfo)
+		}
+
+		if response := s.notModified(request, filePath, fileInfo); response != nil {
+			return response
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			continue
+		}
+
+		fileInfo, err = file.Stat()
+		if err != nil || fileInfo.IsDir() {
+			file.Close()
+			continue
+		}
+		if !fileInfo.Mode().IsRegular() {
+			file.Close()
+			return s.refuseSpecialFile(filePath, fileInfo)
+		}
+
+		response := s.serveFile(file, fileInfo, filePath)
+		if isDirRequest && s.LanguageNegotiation {
+			addVary(response.Headers, "Accept-Language")
+			if language != "" {
+				response.Headers["Content-Language"] = language
+			}
+		}
+		return s.applyRange(request, response)
+	}
+
+	if isDirRequest && s.EnableDirListing {
+		if response := s.listDirectory(roots, requestPath); response != nil {
+			return response
+		}
+	}
+
+	return s.createErrorResponse(StatusNotFound, "Not Found")
+}
+
+func (s *Server) refuseSpecialFile(filePath string, fileInfo os.FileInfo) *HTTPResponse {
+	s.Logger.Errorf("Refusing to serve %s: not a regular file (%v)", filePath, fileInfo.Mode().Type())
+
+	if s.SpecialFileStatus == StatusForbidden {
+		return s.createErrorResponse(StatusForbidden, "Forbidden")
+	}
+	return s.createErrorResponse(StatusNotFound, "Not Found")
+}
+
+func (s *Server) roots() []string {
+	if len(s.RootLayers) > 0 {
+		return s.RootLayers
+	}
+	return []string{s.Root}
+}
+
+func (s *Server) serveFile(file *os.File, fileInfo os.FileInfo, filePath string) *HTTPResponse {
+	response := &HTTPResponse{
+		Code:        StatusOK,
 		ContentType: s.getMimeType(filePath),
-		Body:        content,
-		Headers:     make(map[string]string),
+		Headers: map[string]string{
+			"ETag":          s.entityTag(filePath, fileInfo),
+			"Last-Modified": lastModified(fileInfo),
+		},
+	}
+	for key, value := range s.directoryHeaders(filepath.Dir(filePath)) {
+		response.Headers[key] = value
+	}
+
+	if s.streamFile(response.ContentType, fileInfo.Size()) {
+		response.BodyReader = file
+		response.BodySize = fileInfo.Size()
+		return response
+	}
+
+	defer file.Close()
+
+	content := make([]byte, fileInfo.Size())
+	if n, err := io.ReadFull(file, content); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.Logger.Errorf("Short read on %s: got %d of %d bytes, file changed while serving", filePath, n, len(content))
+		} else {
+			s.Logger.Errorf("Read error on %s after %d of %d bytes: %v", filePath, n, len(content), err)
+		}
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+
+	response.Body = content
+	return response
+}
+
+func (s *Server) directoryHeaders(dir string) map[string]string {
+	headersPath := filepath.Join(dir, HeadersFileName)
+
+	s.headersMu.Lock()
+	defer s.headersMu.Unlock()
+
+	info, err := os.Stat(headersPath)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(s.headersCache, dir)
+		return nil
+	}
+
+	if cached, ok := s.headersCache[dir]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.headers
+	}
+
+	headers, err := s.parseHeadersFile(headersPath)
+	if err != nil {
+		s.Logger.Errorf("Error reading %s: %v", headersPath, err)
+		return nil
 	}
+
+	if s.headersCache == nil {
+		s.headersCache = make(map[string]*dirHeaders)
+	}
+	s.headersCache[dir] = &dirHeaders{modTime: info.ModTime(), headers: headers}
+
+	return headers
+}
+
+func (s *Server) parseHeadersFile(headersPath string) (map[string]string, error) {
+	data, err := os.ReadFile(headersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok || !isValidHeaderName(key) {
+			s.Logger.Errorf("%s:%d: invalid header line, skipping", headersPath, i+1)
+			continue
+		}
+
+		if reservedHeaders[strings.ToLower(key)] {
+			s.Logger.Errorf("%s:%d: %s is managed by the server, skipping", headersPath, i+1, key)
+			continue
+		}
+
+		// The rest of the server looks headers up by their canonical
+		// spelling, so "cache-control" must land on "Cache-Control".
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+
+	return headers, nil
+}
+
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (r *HTTPResponse) statusLine() string {
+	return fmt.Sprintf("%d %s", r.Code, r.reason())
+}
+
+// reason is the reason phrase sent with the status code.
+func (r *HTTPResponse) reason() string {
+	if r.Reason == "" {
+		return statusText(r.Code)
+	}
+	return r.Reason
+}
+
+// isSuccessStatus reports whether code is a 2xx or 3xx; only 4xx and 5xx
+// responses count as errors in the stats.
+func isSuccessStatus(code int) bool {
+	return code >= 200 && code < 400
 }
 
 func (s *Server) sendResponse(conn net.Conn, response *HTTPResponse) error {
+	defer response.closeBody()
+
+	// Shutdown may have started, or the connection outlived
+	// MaxConnLifetime, while the handler ran.
+	if response.keepAlive && !s.keepOpen(response.opened) {
+		response.keepAlive = false
+	}
 
-	headers := fmt.Sprintf("HTTP/1.1 %s\r\n", response.Status)
-	headers += fmt.Sprintf("Server: %s\r\n", ServerName)
-	headers += fmt.Sprintf("Date: %s\r\n", time.Now().UTC().Format(time.RFC1123))
-	headers += fmt.Sprintf("Content-Type: %s\r\n", response.ContentType)
-	headers += fmt.Sprintf("Content-Length: %d\r\n", len(response.Body))
-	headers += "Connection: close\r\n"
+	headers := fmt.Sprintf("HTTP/1.1 %s\r\n", response.statusLine())
+	headers += fmt.Sprintf("Server: %s\r\n", s.serverHeader())
+	if s.PoweredBy != "" {
+		headers += fmt.Sprintf("X-Powered-By: %s\r\n", s.PoweredBy)
+	}
+	if s.AltSvc != "" {
+		headers += fmt.Sprintf("Alt-Svc: %s\r\n", s.AltSvc)
+	}
+	if !s.DisableDateHeader {
+		headers += fmt.Sprintf("Date: %s\r\n", s.now().UTC().Format(time.RFC1123))
+	}
+	if response.ContentType != "" {
+		headers += fmt.Sprintf("Content-Type: %s\r\n", response.ContentType)
+	}
+	// A 304 describes the stored representation, so a zero length would
+	// be wrong; it has no body either way.
+	if response.Code != StatusNotModified {
+		headers += fmt.Sprintf("Content-Length: %d\r\n", response.contentLength())
+	}
+	headers += s.connectionFields(response.keepAlive, response.served)
 
 	for key, value := range response.Headers {
 		headers += fmt.Sprintf("%s: %s\r\n", key, value)
@@ -247,8 +1442,13 @@ func (s *Server) sendResponse(conn net.Conn, response *HTTPResponse) error {
 		return err
 	}
 
-	if len(response.Body) > 0 {
-		if _, err := conn.Write(response.Body); err != nil {
+	if response.contentLength() > 0 && !response.omitBody {
+		var body io.Writer = conn
+		if s.WriteProgressTimeout > 0 {
+			body = &progressWriter{conn: conn, timeout: s.WriteProgressTimeout}
+		}
+
+		if err := writeBody(body, response); err != nil {
 			return err
 		}
 	}
@@ -256,12 +1456,53 @@ func (s *Server) sendResponse(conn net.Conn, response *HTTPResponse) error {
 	return nil
 }
 
-func (s *Server) sendErrorResponse(conn net.Conn, status, message string) {
-	response := s.createErrorResponse(status, message)
+type progressWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := min(written+WriteChunkSize, len(p))
+
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+		n, err := w.conn.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Server) sendErrorResponse(conn net.Conn, code int, message string, detail error) {
+	if detail != nil && s.ErrorVerbosity == ErrorVerbosityDebug {
+		message = fmt.Sprintf("%s: %v", message, detail)
+	}
+
+	response := s.createErrorResponse(code, message)
+	s.applyErrorPage(nil, response)
 	s.sendResponse(conn, response)
 }
 
-func (s *Server) createErrorResponse(status, message string) *HTTPResponse {
+func (s *Server) createErrorResponse(code int, message string) *HTTPResponse {
+	if s.ErrorVerbosity == ErrorVerbosityMinimal {
+		return &HTTPResponse{
+			Code:         code,
+			Headers:      make(map[string]string),
+			errorMessage: message,
+		}
+	}
+
+	status := fmt.Sprintf("%d %s", code, statusText(code))
 	body := fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head>
@@ -279,65 +1520,234 @@ func (s *Server) createErrorResponse(status, message string) *HTTPResponse {
     <hr>
     <div class="footer">%s</div>
 </body>
-</html>`, status, status, message, ServerName)
+</html>`, status, status, html.EscapeString(message), ServerName)
 
 	return &HTTPResponse{
-		Status:      status,
-		ContentType: "text/html",
-		Body:        []byte(body),
-		Headers:     make(map[string]string),
+		Code:         code,
+		ContentType:  "text/html",
+		Body:         []byte(body),
+		Headers:      make(map[string]string),
+		errorMessage: message,
 	}
 }
 
-func (s *Server) getMimeType(filePath string) string {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	if mimeType, exists := mimeTypes[ext]; exists {
-		return mimeType
+func (s *Server) createRedirectResponse(code int, location string) *HTTPResponse {
+	return &HTTPResponse{
+		Code:    code,
+		Headers: map[string]string{"Location": location},
 	}
-	return "application/octet-stream"
 }
 
-func (s *Server) isSafePath(path string) bool {
-	return !strings.Contains(path, "..") && !strings.Contains(path, "~")
+// resolvePath maps requestPath to a file under root. It reports false
+// when the cleaned result would land outside root, whatever the path
+// looks like textually.
+func resolvePath(root, requestPath string) (string, bool) {
+	filePath := filepath.Join(root, filepath.FromSlash(requestPath))
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filePath, true
 }
 
-func (s *Server) logRequest(request *HTTPRequest, status string) {
-	log.Printf("[%s] %s %s - %s",
+func (s *Server) logRequest(request *HTTPRequest, code int, reason string, timings *requestTimings) {
+	line := fmt.Sprintf("[%s] %s %s - %d %s",
 		time.Now().Format("2006/01/02 15:04:05"),
 		request.Method,
 		request.Path,
-		status)
+		code,
+		reason)
+
+	if peer, _, _ := net.SplitHostPort(request.RemoteAddr); request.ClientIP != "" && request.ClientIP != peer {
+		line += " client=" + request.ClientIP
+	}
+	if s.LogTLS && request.TLS != nil {
+		line += fmt.Sprintf(" tls=%s cipher=%s sni=%s",
+			tls.VersionName(request.TLS.Version),
+			tls.CipherSuiteName(request.TLS.CipherSuite),
+			request.TLS.ServerName)
+		if request.ClientSubject != "" {
+			line += fmt.Sprintf(" client_cert=%q", request.ClientSubject)
+		}
+	}
+	if timings != nil {
+		line += fmt.Sprintf(" (%s)", timings)
+	}
+
+	s.Logger.Infof("%s", line)
+}
+
+func (s *Server) isLogExcluded(requestPath string) bool {
+	if i := strings.IndexByte(requestPath, '?'); i >= 0 {
+		requestPath = requestPath[:i]
+	}
+
+	for _, pattern := range s.AccessLogExclude {
+		if matched, _ := path.Match(pattern, requestPath); matched {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Server) printStats() {
 	uptime := time.Since(s.Stats.StartTime)
+	total := atomic.LoadInt64(&s.Stats.TotalRequests)
+	successful := atomic.LoadInt64(&s.Stats.SuccessfulRequests)
 	successRate := float64(0)
-	if s.Stats.TotalRequests > 0 {
-		successRate = float64(s.Stats.SuccessfulRequests) / float64(s.Stats.TotalRequests) * 100
+	if total > 0 {
+		successRate = float64(successful) / float64(total) * 100
+	}
+
+	s.Logger.Infof("=== Server Statistics ===")
+	s.Logger.Infof("Uptime: %v", uptime.Round(time.Second))
+	s.Logger.Infof("Total requests: %d", total)
+	s.Logger.Infof("Successful requests: %d", successful)
+	s.Logger.Infof("Error requests: %d", atomic.LoadInt64(&s.Stats.ErrorRequests))
+	s.Logger.Infof("Success rate: %.1f%%", successRate)
+	s.Logger.Infof("Open connections: %d", atomic.LoadInt64(&s.Stats.OpenConnections))
+	s.Logger.Infof("In-flight requests: %d", atomic.LoadInt64(&s.Stats.InFlightRequests))
+	s.Logger.Infof("Rejected connections: %d", atomic.LoadInt64(&s.Stats.RejectedConnections))
+	s.Logger.Infof("Evicted idle connections: %d", atomic.LoadInt64(&s.Stats.EvictedConnections))
+	s.Logger.Infof("Requests/sec (%ds avg): %.1f", RateWindowSeconds, s.Stats.RequestsPerSecond())
+	routes := s.Stats.Routes()
+	for _, route := range routeNames(routes) {
+		counts := routes[route]
+		s.Logger.Infof("Route %s: %d requests, %d bytes in, %d bytes out", route, counts.Requests, counts.RequestBytes, counts.ResponseBytes)
+	}
+	s.Logger.Infof("========================")
+}
+
+func (s *Server) sampleRequestRate() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var samples [RateWindowSeconds]int64
+	last := atomic.LoadInt64(&s.Stats.TotalRequests)
+
+	for tick := 0; ; tick++ {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		total := atomic.LoadInt64(&s.Stats.TotalRequests)
+		samples[tick%len(samples)] = total - last
+		last = total
+
+		var sum int64
+		for _, n := range samples {
+			sum += n
+		}
+		rate := float64(sum) / float64(min(tick+1, len(samples)))
+		atomic.StoreUint64(&s.Stats.requestRate, math.Float64bits(rate))
+	}
+}
+
+func (s *Server) startAdmin() error {
+	listener, err := net.Listen("tcp", s.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin address %s: %v", s.AdminAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	s.admin = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: s.HeaderTimeout,
+		ErrorLog:          log.New(loggerWriter{s.Logger}, "admin: ", 0),
+	}
+
+	s.Logger.Infof("Admin endpoints listening on %s", listener.Addr())
+
+	go func() {
+		if err := s.admin.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.Logger.Errorf("Admin server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "simplehttp_uptime_seconds %d\n", int64(time.Since(s.Stats.StartTime).Seconds()))
+	fmt.Fprintf(w, "simplehttp_requests_total %d\n", atomic.LoadInt64(&s.Stats.TotalRequests))
+	fmt.Fprintf(w, "simplehttp_requests_successful_total %d\n", atomic.LoadInt64(&s.Stats.SuccessfulRequests))
+	fmt.Fprintf(w, "simplehttp_requests_error_total %d\n", atomic.LoadInt64(&s.Stats.ErrorRequests))
+	fmt.Fprintf(w, "simplehttp_open_connections %d\n", atomic.LoadInt64(&s.Stats.OpenConnections))
+	fmt.Fprintf(w, "simplehttp_inflight_requests %d\n", atomic.LoadInt64(&s.Stats.InFlightRequests))
+	fmt.Fprintf(w, "simplehttp_rejected_connections_total %d\n", atomic.LoadInt64(&s.Stats.RejectedConnections))
+	fmt.Fprintf(w, "simplehttp_evicted_connections_total %d\n", atomic.LoadInt64(&s.Stats.EvictedConnections))
+	fmt.Fprintf(w, "simplehttp_requests_per_second %.2f\n", s.Stats.RequestsPerSecond())
+
+	// Summaries without quantiles: the sums over the counts give the
+	// average body size per route.
+	routes := s.Stats.Routes()
+	for _, route := range routeNames(routes) {
+		counts := routes[route]
+		fmt.Fprintf(w, "simplehttp_route_request_bytes_sum{route=%q} %d\n", route, counts.RequestBytes)
+		fmt.Fprintf(w, "simplehttp_route_request_bytes_count{route=%q} %d\n", route, counts.Requests)
+		fmt.Fprintf(w, "simplehttp_route_response_bytes_sum{route=%q} %d\n", route, counts.ResponseBytes)
+		fmt.Fprintf(w, "simplehttp_route_response_bytes_count{route=%q} %d\n", route, counts.Requests)
 	}
+}
 
-	fmt.Println("\n=== Server Statistics ===")
-	fmt.Printf("Uptime: %v\n", uptime.Round(time.Second))
-	fmt.Printf("Total requests: %d\n", s.Stats.TotalRequests)
-	fmt.Printf("Successful requests: %d\n", s.Stats.SuccessfulRequests)
-	fmt.Printf("Error requests: %d\n", s.Stats.ErrorRequests)
-	fmt.Printf("Success rate: %.1f%%\n", successRate)
-	fmt.Println("========================")
+type loggerWriter struct {
+	logger Logger
 }
 
-func (s *Server) handleShutdown() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+func (w loggerWriter) Write(p []byte) (int, error) {
+	w.logger.Errorf("%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func isAdminPath(path string) bool {
+	return path == "/metrics" || strings.HasPrefix(path, "/metrics?") ||
+		strings.HasPrefix(path, "/debug/vars") || strings.HasPrefix(path, "/debug/pprof")
+}
 
-	<-sigChan
-	fmt.Println("\nShutting down server...")
+func (s *Server) handleShutdown(ctx context.Context) {
+	var stdinClosed chan struct{}
+	if s.ShutdownOnStdinClose {
+		stdinClosed = make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, os.Stdin)
+			close(stdinClosed)
+		}()
+	}
 
-	if s.listener != nil {
-		s.listener.Close()
+	select {
+	case <-ctx.Done():
+	case <-stdinClosed:
+		s.Logger.Infof("Standard input closed")
+	case <-s.stopped:
+		// Shut down directly through Shutdown.
+		return
 	}
 
-	s.printStats()
-	os.Exit(0)
+	// Start returns Shutdown's error.
+	grace, cancel := context.WithTimeout(context.Background(), s.GracePeriod)
+	defer cancel()
+	s.Shutdown(grace)
 }
 
 func setupSampleWebsite() {
@@ -493,6 +1903,74 @@ document.addEventListener('DOMContentLoaded', function() {
 func main() {
 	port := DefaultPort
 	root := DocumentRoot
+	grace := ShutdownGracePeriod
+	adminAddr := ""
+	strictLineEndings := false
+	disableDate := false
+	var logExclude []string
+	accessLogPath := ""
+	vhosts := make(map[string]string)
+	extraMIMETypes := make(map[string]string)
+	errorPages := make(map[int]string)
+	accessLogFormat := AccessLogCommon
+	errorVerbosity := ErrorVerbosityStandard
+	var writeProgress time.Duration
+	reusePort := false
+	redirectCanonical := false
+	poweredBy := ""
+	var rootLayers []string
+	testMode := false
+	var testModeClients []string
+	archivePath := ""
+	defaultLanguage := ""
+	requireHost := true
+	var requestTimeout time.Duration
+	var acmeDomains []string
+	acmeCacheDir := "acme-cache"
+	certFile := ""
+	keyFile := ""
+	tlsPort := ""
+	clientCAFile := ""
+	redirectHTTPS := false
+	sitemapBaseURL := ""
+	syslogSpec := ""
+	specialFileStatus := StatusNotFound
+	contentETags := false
+	logTimings := false
+	maintenance := false
+	var maintenanceWindows []MaintenanceWindow
+	retryAfter := DefaultRetryAfter
+	versionPath := ""
+	statsPath := ""
+	serverHeaderVersion := false
+	lenientMethodCase := false
+	extensionlessType := ""
+	cspTemplate := ""
+	altSvc := ""
+	stdinShutdown := false
+	var baseHrefRules []BaseHrefRule
+	var deprecationRules []DeprecationRule
+	var authRules []AuthRule
+	var trustedProxies []string
+	problemDetails := false
+	dualStack := false
+	logTLS := false
+	allowHTTP09 := false
+	idleTimeout := DefaultIdleTimeout
+	headerTimeout := DefaultHeaderTimeout
+	readTimeout := DefaultReadTimeout
+	writeTimeout := DefaultWriteTimeout
+	maxKeepAlive := DefaultMaxKeepAlive
+	var maxConnLifetime time.Duration
+	var maxBodySize int64 = DefaultMaxBodySize
+	maxConnections := 0
+	evictIdle := true
+	busyBody := ""
+	busyPage := ""
+	var busyRetryAfter time.Duration
+	dirListing := false
+	compress := true
+	compressionLevel := gzip.DefaultCompression
 
 	if len(os.Args) > 1 {
 		for i, arg := range os.Args[1:] {
@@ -505,6 +1983,360 @@ func main() {
 				if i+2 < len(os.Args) {
 					root = os.Args[i+2]
 				}
+			case "--grace":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid grace period %q: %v", os.Args[i+2], err)
+					}
+					grace = d
+				}
+			case "--admin":
+				if i+2 < len(os.Args) {
+					adminAddr = os.Args[i+2]
+				}
+			case "--strict-line-endings":
+				strictLineEndings = true
+			case "--no-date":
+				disableDate = true
+			case "--log-exclude":
+				if i+2 < len(os.Args) {
+					logExclude = append(logExclude, strings.Split(os.Args[i+2], ",")...)
+				}
+			case "--error-page":
+				if i+2 < len(os.Args) {
+					codeText, page, ok := strings.Cut(os.Args[i+2], "=")
+					code, err := strconv.Atoi(codeText)
+					if !ok || err != nil || code < 400 || code > 599 || page == "" {
+						log.Fatalf("Invalid --error-page %q: want CODE=PATH with a 4xx or 5xx code", os.Args[i+2])
+					}
+					errorPages[code] = page
+				}
+			case "--vhost":
+				if i+2 < len(os.Args) {
+					host, dir, ok := strings.Cut(os.Args[i+2], "=")
+					if !ok || host == "" || dir == "" {
+						log.Fatalf("Invalid --vhost %q: want HOST=DIR", os.Args[i+2])
+					}
+					vhosts[host] = dir
+				}
+			case "--mime-type":
+				if i+2 < len(os.Args) {
+					ext, mimeType, ok := strings.Cut(os.Args[i+2], "=")
+					if !ok || ext == "" || mimeType == "" {
+						log.Fatalf("Invalid --mime-type %q: want EXT=TYPE", os.Args[i+2])
+					}
+					extraMIMETypes[ext] = mimeType
+				}
+			case "--access-log":
+				if i+2 < len(os.Args) {
+					accessLogPath = os.Args[i+2]
+				}
+			case "--access-log-format":
+				if i+2 < len(os.Args) {
+					accessLogFormat = os.Args[i+2]
+					if accessLogFormat != AccessLogCommon && accessLogFormat != AccessLogCombined {
+						log.Fatalf("Invalid access log format %q: want common or combined", accessLogFormat)
+					}
+				}
+			case "--errors":
+				if i+2 < len(os.Args) {
+					switch os.Args[i+2] {
+					case ErrorVerbosityMinimal, ErrorVerbosityStandard, ErrorVerbosityDebug:
+						errorVerbosity = os.Args[i+2]
+					default:
+						log.Fatalf("Invalid error verbosity %q: want minimal, standard or debug", os.Args[i+2])
+					}
+				}
+			case "--write-progress-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid write progress timeout %q: %v", os.Args[i+2], err)
+					}
+					writeProgress = d
+				}
+			case "--reuseport":
+				reusePort = true
+			case "--redirect-canonical":
+				redirectCanonical = true
+			case "--powered-by":
+				if i+2 < len(os.Args) {
+					poweredBy = os.Args[i+2]
+				}
+			case "--layers":
+				if i+2 < len(os.Args) {
+					rootLayers = strings.Split(os.Args[i+2], ",")
+				}
+			case "--test-mode":
+				testMode = true
+			case "--test-mode-clients":
+				if i+2 < len(os.Args) {
+					testModeClients = strings.Split(os.Args[i+2], ",")
+				}
+			case "--archive":
+				if i+2 < len(os.Args) {
+					archivePath = os.Args[i+2]
+				}
+			case "--negotiate-language":
+				if i+2 < len(os.Args) {
+					defaultLanguage = os.Args[i+2]
+				}
+			case "--allow-missing-host":
+				requireHost = false
+			case "--request-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid request timeout %q: %v", os.Args[i+2], err)
+					}
+					requestTimeout = d
+				}
+			case "--acme":
+				if i+2 < len(os.Args) {
+					acmeDomains = strings.Split(os.Args[i+2], ",")
+				}
+			case "--acme-cache":
+				if i+2 < len(os.Args) {
+					acmeCacheDir = os.Args[i+2]
+				}
+			case "--cert":
+				if i+2 < len(os.Args) {
+					certFile = os.Args[i+2]
+				}
+			case "--key":
+				if i+2 < len(os.Args) {
+					keyFile = os.Args[i+2]
+				}
+			case "--tls-port":
+				if i+2 < len(os.Args) {
+					tlsPort = os.Args[i+2]
+				}
+			case "--redirect-https":
+				redirectHTTPS = true
+			case "--client-ca":
+				if i+2 < len(os.Args) {
+					clientCAFile = os.Args[i+2]
+				}
+			case "--sitemap":
+				if i+2 < len(os.Args) {
+					sitemapBaseURL = os.Args[i+2]
+				}
+			case "--syslog":
+				if i+2 < len(os.Args) {
+					syslogSpec = os.Args[i+2]
+				}
+			case "--special-files":
+				if i+2 < len(os.Args) {
+					switch os.Args[i+2] {
+					case "403":
+						specialFileStatus = StatusForbidden
+					case "404":
+						specialFileStatus = StatusNotFound
+					default:
+						log.Fatalf("Invalid special file status %q: want 403 or 404", os.Args[i+2])
+					}
+				}
+			case "--etag":
+				if i+2 < len(os.Args) {
+					switch os.Args[i+2] {
+					case "content":
+						contentETags = true
+					case "mtime":
+						contentETags = false
+					default:
+						log.Fatalf("Invalid ETag strategy %q: want mtime or content", os.Args[i+2])
+					}
+				}
+			case "--log-timings":
+				logTimings = true
+			case "--maintenance":
+				maintenance = true
+			case "--maintenance-window":
+				if i+2 < len(os.Args) {
+					for _, spec := range strings.Split(os.Args[i+2], ",") {
+						w, err := ParseMaintenanceWindow(spec)
+						if err != nil {
+							log.Fatalf("Invalid maintenance window: %v", err)
+						}
+						maintenanceWindows = append(maintenanceWindows, w)
+					}
+				}
+			case "--retry-after":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil || d < 0 {
+						log.Fatalf("Invalid retry-after %q", os.Args[i+2])
+					}
+					retryAfter = d
+				}
+			case "--expose-version":
+				if versionPath == "" {
+					versionPath = DefaultVersionPath
+				}
+			case "--version-path":
+				if i+2 < len(os.Args) {
+					versionPath = os.Args[i+2]
+				}
+			case "--expose-stats":
+				if statsPath == "" {
+					statsPath = DefaultStatsPath
+				}
+			case "--stats-path":
+				if i+2 < len(os.Args) {
+					statsPath = os.Args[i+2]
+				}
+			case "--server-version":
+				serverHeaderVersion = true
+			case "-v", "--version":
+				fmt.Printf("%s %s (commit %s, built %s)\n", ServerName, Version, Commit, BuildDate)
+				return
+			case "--lenient-method-case":
+				lenientMethodCase = true
+			case "--extensionless-type":
+				if i+2 < len(os.Args) {
+					extensionlessType = os.Args[i+2]
+				}
+			case "--csp":
+				if i+2 < len(os.Args) {
+					cspTemplate = os.Args[i+2]
+				}
+			case "--alt-svc":
+				if i+2 < len(os.Args) {
+					altSvc = os.Args[i+2]
+				}
+			case "--stdin-shutdown":
+				stdinShutdown = true
+			case "--base-href":
+				if i+2 < len(os.Args) {
+					rules, err := ParseBaseHrefRules(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid --base-href: %v", err)
+					}
+					baseHrefRules = rules
+				}
+			case "--auth":
+				if i+2 < len(os.Args) {
+					rule, err := ParseAuthRule(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid --auth: %v", err)
+					}
+					authRules = append(authRules, rule)
+				}
+			case "--deprecate":
+				if i+2 < len(os.Args) {
+					rules, err := ParseDeprecationRules(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid --deprecate: %v", err)
+					}
+					deprecationRules = rules
+				}
+			case "--trusted-proxies":
+				if i+2 < len(os.Args) {
+					trustedProxies = strings.Split(os.Args[i+2], ",")
+				}
+			case "--problem-json":
+				problemDetails = true
+			case "--dual-stack":
+				dualStack = true
+			case "--log-tls":
+				logTLS = true
+			case "--http09":
+				allowHTTP09 = true
+			case "--idle-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid idle timeout %q: %v", os.Args[i+2], err)
+					}
+					idleTimeout = d
+				}
+			case "--max-conn-lifetime":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid connection lifetime %q: %v", os.Args[i+2], err)
+					}
+					maxConnLifetime = d
+				}
+			case "--header-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid header timeout %q: %v", os.Args[i+2], err)
+					}
+					headerTimeout = d
+				}
+			case "--read-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid read timeout %q: %v", os.Args[i+2], err)
+					}
+					readTimeout = d
+				}
+			case "--write-timeout":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid write timeout %q: %v", os.Args[i+2], err)
+					}
+					writeTimeout = d
+				}
+			case "--max-keepalive-requests":
+				if i+2 < len(os.Args) {
+					n, err := strconv.Atoi(os.Args[i+2])
+					if err != nil || n < 0 {
+						log.Fatalf("Invalid max keep-alive requests %q", os.Args[i+2])
+					}
+					maxKeepAlive = n
+				}
+			case "--max-body-size":
+				if i+2 < len(os.Args) {
+					n, err := strconv.ParseInt(os.Args[i+2], 10, 64)
+					if err != nil || n < 0 {
+						log.Fatalf("Invalid max body size %q", os.Args[i+2])
+					}
+					maxBodySize = n
+				}
+			case "--max-connections":
+				if i+2 < len(os.Args) {
+					n, err := strconv.Atoi(os.Args[i+2])
+					if err != nil || n < 0 {
+						log.Fatalf("Invalid max connections %q", os.Args[i+2])
+					}
+					maxConnections = n
+				}
+			case "--no-idle-eviction":
+				evictIdle = false
+			case "--busy-body":
+				if i+2 < len(os.Args) {
+					busyBody = os.Args[i+2]
+				}
+			case "--busy-page":
+				if i+2 < len(os.Args) {
+					busyPage = os.Args[i+2]
+				}
+			case "--busy-retry-after":
+				if i+2 < len(os.Args) {
+					d, err := time.ParseDuration(os.Args[i+2])
+					if err != nil || d < 0 {
+						log.Fatalf("Invalid busy retry-after %q", os.Args[i+2])
+					}
+					busyRetryAfter = d
+				}
+			case "--dir-listing":
+				dirListing = true
+			case "--no-compress":
+				compress = false
+			case "--compression-level":
+				if i+2 < len(os.Args) {
+					n, err := strconv.Atoi(os.Args[i+2])
+					if err != nil {
+						log.Fatalf("Invalid compression level %q", os.Args[i+2])
+					}
+					compressionLevel = n
+				}
 			case "--setup":
 				setupSampleWebsite()
 				fmt.Println("Sample website created in", DocumentRoot)
@@ -512,11 +2344,82 @@ func main() {
 			case "-h", "--help":
 				fmt.Println("SimpleHTTP Server")
 				fmt.Println("Usage:")
-				fmt.Println("  go run main.go [options]")
+				fmt.Println("  go run . [options]")
 				fmt.Println("Options:")
 				fmt.Println("  -p, --port PORT    Server port (default: 8080)")
 				fmt.Println("  -r, --root PATH    Document root (default: ./www)")
+				fmt.Println("  --grace DURATION   Shutdown grace period (default: 10s)")
+				fmt.Println("  --admin ADDR       Serve /metrics, /debug/vars and pprof on ADDR (e.g. localhost:9090)")
+				fmt.Println("  --strict-line-endings  Reject requests with bare LF or bare CR line endings")
+				fmt.Println("  --no-date          Omit the Date response header")
+				fmt.Println("  --log-exclude PATTERNS  Comma-separated path patterns to leave out of the access log")
+				fmt.Println("  --error-page CODE=PATH  Send the page at PATH under the root for CODE errors, e.g. 404=/errors/404.html (repeatable)")
+				fmt.Println("  --vhost HOST=DIR   Serve requests for HOST from DIR instead of -r (repeatable)")
+				fmt.Println("  --mime-type EXT=TYPE  Serve files ending in EXT as TYPE, e.g. .wasm=application/wasm (repeatable)")
+				fmt.Println("  --access-log FILE  Also append each request to FILE in Common Log Format")
+				fmt.Println("  --access-log-format FORMAT  common or combined, which adds Referer and User-Agent (default: common)")
+				fmt.Println("  --errors LEVEL     Error page detail: minimal, standard or debug (default: standard)")
+				fmt.Println("  --write-progress-timeout DURATION  Extend the write deadline after every chunk instead of using a fixed one")
+				fmt.Println("  --reuseport        Set SO_REUSEPORT so several processes can share the port")
+				fmt.Println("  --redirect-canonical  301-redirect non-canonical paths such as /a//b or /a/./b")
+				fmt.Println("  --powered-by VALUE  Send an X-Powered-By header (omitted by default)")
+				fmt.Println("  --layers PATHS     Comma-separated document roots, searched in order (overrides -r)")
+				fmt.Println("  --test-mode        DANGEROUS: honour ?__delay= and ?__status= from trusted clients (QA only)")
+				fmt.Println("  --test-mode-clients IPS  Comma-separated IPs/CIDRs trusted by test mode (default: loopback only)")
+				fmt.Println("  --archive FILE     Serve the site from a .zip, .tar or .tar.gz archive (overrides -r)")
+				fmt.Println("  --negotiate-language DEFAULT  Serve index.<lang>.html by Accept-Language, falling back to DEFAULT")
+				fmt.Println("  --allow-missing-host  Serve HTTP/1.1 requests that lack a Host header")
+				fmt.Println("  --request-timeout DURATION  Hard limit on a request's whole lifecycle (default: none)")
+				fmt.Println("  --acme DOMAINS     Serve HTTPS with Let's Encrypt certificates for these comma-separated domains (needs -tags acme)")
+				fmt.Println("  --acme-cache DIR   Where ACME certificates are cached (default: ./acme-cache)")
+				fmt.Println("  --cert FILE        PEM certificate for HTTPS (with --key)")
+				fmt.Println("  --key FILE         PEM private key for HTTPS")
+				fmt.Println("  --tls-port PORT    Serve HTTPS on PORT and keep plain HTTP on -p (default: HTTPS on -p)")
+				fmt.Println("  --redirect-https   Redirect plain HTTP requests to HTTPS on --tls-port")
+				fmt.Println("  --client-ca FILE   Require HTTPS clients to present a certificate signed by a CA in this PEM file")
+				fmt.Println("  --sitemap BASEURL  Generate /sitemap.xml for the HTML pages, with URLs under BASEURL")
+				fmt.Println("  --syslog FACILITY[.PRIORITY]  Send logs to syslog, e.g. local0 or daemon.notice")
+				fmt.Println("  --special-files STATUS  Answer requests for FIFOs, sockets and devices with 403 or 404 (default: 404)")
+				fmt.Println("  --etag STRATEGY    Derive file ETags from mtime and size or from a content hash: mtime or content (default: mtime)")
+				fmt.Println("  --log-timings      Log how long each request spent being parsed, handled and written")
+				fmt.Println("  --maintenance      Start in maintenance mode (every request gets 503)")
+				fmt.Println("  --maintenance-window HH:MM/DURATION  Daily maintenance windows, comma-separated, e.g. 02:00/30m")
+				fmt.Println("  --retry-after DURATION  Retry-After on 503s for rejected connections and maintenance (default: 5s)")
+				fmt.Println("  --expose-version   Serve build info as JSON at /version")
+				fmt.Println("  --version-path PATH  Serve build info as JSON at PATH instead")
+				fmt.Println("  --expose-stats     Serve request statistics as JSON at /server-status")
+				fmt.Println("  --stats-path PATH  Serve request statistics as JSON at PATH instead")
+				fmt.Println("  --server-version   Include the build version in the Server header")
+				fmt.Println("  --lenient-method-case  Accept methods in any case (\"get\" as GET) instead of answering 400")
+				fmt.Println("  --extensionless-type TYPE  Content-Type for files without an extension, e.g. \"text/plain; charset=utf-8\"")
+				fmt.Println("  --csp POLICY       Send a Content-Security-Policy header, replacing {nonce} with a per-request nonce")
+				fmt.Println("  --alt-svc VALUE    Advertise alternative services, e.g. 'h3=\":443\"; ma=86400'")
+				fmt.Println("  --stdin-shutdown   Shut down gracefully when standard input is closed")
+				fmt.Println("  --base-href RULES  Inject <base href> into HTML under a path prefix, e.g. /docs/=/app/docs/ (comma-separated)")
+				fmt.Println("  --auth /PREFIX=REALM:USER:PASSWORD  Require Basic auth under PREFIX (repeatable, one user each)")
+				fmt.Println("  --deprecate RULES  Send Deprecation/Sunset headers, e.g. /api/v1/*=2026-01-01/2026-06-30 (comma-separated)")
+				fmt.Println("  --trusted-proxies IPS  Comma-separated IPs/CIDRs whose Forwarded/X-Forwarded-* headers are trusted")
+				fmt.Println("  --problem-json     Send errors as application/problem+json to clients that prefer JSON")
+				fmt.Println("  --dual-stack       Listen on 0.0.0.0 and [::] separately instead of relying on the OS default")
+				fmt.Println("  --log-tls          Log the TLS version, cipher suite and SNI name of each HTTPS request")
+				fmt.Println("  --http09           Answer legacy HTTP/0.9 requests (\"GET /path\") with the bare body")
+				fmt.Println("  --idle-timeout DURATION  How long a keep-alive connection may idle between requests, 0 disables keep-alive (default: 5s)")
+				fmt.Println("  --max-conn-lifetime DURATION  Close a connection after its current response once it is this old (default: unlimited)")
+				fmt.Println("  --header-timeout DURATION  Time allowed to send the request line and headers, 0 for no limit (default: 10s)")
+				fmt.Println("  --read-timeout DURATION  Time allowed to send the whole request including its body (default: 30s)")
+				fmt.Println("  --write-timeout DURATION  Time allowed to write the response (default: 30s)")
+				fmt.Println("  --max-keepalive-requests N  Close a connection after N requests, 0 for no limit (default: 100)")
+				fmt.Println("  --max-body-size BYTES  Largest POST/PUT/PATCH body accepted (default: 1048576)")
+				fmt.Println("  --max-connections N  Serve at most N connections at once, answering 503 beyond that (default: no limit)")
+				fmt.Println("  --no-idle-eviction  At the connection limit, answer 503 instead of closing the longest idle keep-alive connection")
+				fmt.Println("  --busy-body TEXT   Plain-text body of the 503 sent to connections beyond --max-connections")
+				fmt.Println("  --busy-page PATH   Send the page at PATH under the root as that 503 instead, e.g. /errors/busy.html")
+				fmt.Println("  --busy-retry-after DURATION  Retry-After on that 503 (default: --retry-after)")
+				fmt.Println("  --dir-listing      List the contents of directories that have no index.html")
+				fmt.Println("  --no-compress      Never gzip responses")
+				fmt.Println("  --compression-level N  gzip level from 1 (fastest) to 9 (smallest), -1 for the default")
 				fmt.Println("  --setup            Create sample website")
+				fmt.Println("  -v, --version      Print build info and exit")
 				fmt.Println("  -h, --help         Show this help")
 				return
 			}
@@ -524,7 +2427,112 @@ func main() {
 	}
 
 	server := NewServer(port, root)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	if archivePath != "" {
+		var err error
+		server, err = NewArchiveServer(port, archivePath)
+		if err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}
+	server.GracePeriod = grace
+	server.AdminAddr = adminAddr
+	server.StrictLineEndings = strictLineEndings
+	server.DisableDateHeader = disableDate
+	server.AccessLogExclude = logExclude
+	server.AccessLogFormat = accessLogFormat
+	server.ErrorPages = errorPages
+	for host, dir := range vhosts {
+		server.AddVHost(host, dir)
+	}
+	for ext, mimeType := range extraMIMETypes {
+		server.SetMimeType(ext, mimeType)
+	}
+	if accessLogPath != "" {
+		f, err := os.OpenFile(accessLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Cannot open access log: %v", err)
+		}
+		defer f.Close()
+		server.AccessLog = f
+	}
+	server.ErrorVerbosity = errorVerbosity
+	server.WriteProgressTimeout = writeProgress
+	server.ReusePort = reusePort
+	server.RedirectNonCanonical = redirectCanonical
+	server.PoweredBy = poweredBy
+	server.RootLayers = rootLayers
+	server.TestMode = testMode
+	server.TestModeClients = testModeClients
+	server.RequireHost = requireHost
+	server.RequestTimeout = requestTimeout
+	server.ACMEDomains = acmeDomains
+	server.ACMECacheDir = acmeCacheDir
+	server.CertFile = certFile
+	server.KeyFile = keyFile
+	server.TLSPort = tlsPort
+	server.RedirectHTTPS = redirectHTTPS
+	server.ClientCAFile = clientCAFile
+	server.SitemapBaseURL = sitemapBaseURL
+	server.SpecialFileStatus = specialFileStatus
+	server.ContentETags = contentETags
+	server.LogTimings = logTimings
+	server.MaintenanceWindows = maintenanceWindows
+	server.RetryAfter = retryAfter
+	server.SetMaintenance(maintenance)
+	server.VersionPath = versionPath
+	server.StatsPath = statsPath
+	server.ServerHeaderVersion = serverHeaderVersion
+	server.LenientMethodCase = lenientMethodCase
+	server.ExtensionlessType = extensionlessType
+	server.CSPTemplate = cspTemplate
+	server.AltSvc = altSvc
+	server.ShutdownOnStdinClose = stdinShutdown
+	server.BaseHrefRules = baseHrefRules
+	server.DeprecationRules = deprecationRules
+	server.AuthRules = authRules
+	server.TrustedProxies = trustedProxies
+	server.ProblemDetails = problemDetails
+	server.DualStack = dualStack
+	server.LogTLS = logTLS
+	server.AllowHTTP09 = allowHTTP09
+	server.IdleTimeout = idleTimeout
+	server.HeaderTimeout = headerTimeout
+	server.ReadTimeout = readTimeout
+	server.WriteTimeout = writeTimeout
+	server.MaxKeepAliveRequests = maxKeepAlive
+	server.MaxConnLifetime = maxConnLifetime
+	server.MaxBodySize = maxBodySize
+	server.MaxConnections = maxConnections
+	server.EvictIdle = evictIdle
+	if busyBody != "" {
+		server.BusyBody = []byte(busyBody + "\n")
+	}
+	server.BusyPage = busyPage
+	server.BusyRetryAfter = busyRetryAfter
+	server.EnableDirListing = dirListing
+	server.Compress = compress
+	server.CompressionLevel = compressionLevel
+	if syslogSpec != "" {
+		logger, err := NewSyslogLogger(syslogSpec, "simplehttp")
+		if err != nil {
+			log.Printf("Warning: syslog unavailable, logging to stderr: %v", err)
+		} else {
+			server.Logger = logger
+		}
+	}
+	if defaultLanguage != "" {
+		server.LanguageNegotiation = true
+		server.DefaultLanguage = defaultLanguage
+	}
+	// The first SIGINT or SIGTERM starts a graceful shutdown; once it has,
+	// a second one gets the default behaviour and kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := server.Start(ctx); err != nil {
+		log.Fatalf("Server error: %v", err)
 	}
 }
\ No newline at end of file
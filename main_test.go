@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	s := NewServer(DefaultPort, t.TempDir())
+	s.Logger = stdLogger{log.New(io.Discard, "", 0)}
+	return s
+}
+
+// startServer runs s on a free local port until the test ends and
+// returns its address and a channel that receives Start's result.
+func startServer(t *testing.T, s *Server) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s.Port, _ = net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	t.Cleanup(cancel)
+
+	addr := net.JoinHostPort("127.0.0.1", s.Port)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr, done
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// exchange writes raw to a connection served by s and returns everything
+// the server sends back before closing it.
+func exchange(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.handleConnection(server)
+	return roundTrip(t, client, raw)
+}
+
+// roundTrip writes raw to client and returns everything read back before
+// the other end closes.
+func roundTrip(t *testing.T, client net.Conn, raw string) string {
+	t.Helper()
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(client, raw)
+	out, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return string(out)
+}
+
+func statusLine(response string) string {
+	line, _, _ := strings.Cut(response, "\r\n")
+	return line
+}
+
+func parseRaw(s *Server, raw string) (*HTTPRequest, error) {
+	return s.parseRequest(bufio.NewReader(strings.NewReader(raw)))
+}
+
+func TestParseRequestBareLF(t *testing.T) {
+	s := newTestServer(t)
+
+	request, err := parseRaw(s, "GET /a.html HTTP/1.1\nHost: example.com\nX-Test:  yes \n\n")
+	if err != nil {
+		t.Fatalf("parseRequest: %v", err)
+	}
+	if request.Method != "GET" || request.Path != "/a.html" || request.Version != "HTTP/1.1" {
+		t.Errorf("request line = %q %q %q", request.Method, request.Path, request.Version)
+	}
+	if got := request.Headers["host"]; got != "example.com" {
+		t.Errorf("host = %q, want example.com", got)
+	}
+	if got := request.Headers["x-test"]; got != "yes" {
+		t.Errorf("x-test = %q, want yes", got)
+	}
+}
+
+func TestParseRequestEmptyTarget(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name, line string
+		ok         bool
+	}{
+		{"empty target", "GET  HTTP/1.1", true},
+		{"whitespace target", "GET \t  HTTP/1.1", true},
+		{"single separator", "GET HTTP/1.1", false},
+		{"no version", "GET /index.html", false},
+		{"extra field", "GET / HTTP/1.1 x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := parseRaw(s, tt.line+"\r\nHost: example.com\r\n\r\n")
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("parsed %q as %s %s, want an error", tt.line, request.Method, request.Path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRequest: %v", err)
+			}
+			if request.Path != "/" || request.Version != "HTTP/1.1" {
+				t.Errorf("got path %q version %q, want / HTTP/1.1", request.Path, request.Version)
+			}
+		})
+	}
+}
+
+func TestParseRequestLineEndings(t *testing.T) {
+	tests := []struct {
+		name, raw       string
+		lenient, strict bool
+	}{
+		{"CRLF", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", true, true},
+		{"bare LF", "GET / HTTP/1.1\nHost: example.com\n\n", true, false},
+		{"bare CR inside header", "GET / HTTP/1.1\r\nHost: example.com\rX-Smuggled: 1\r\n\r\n", true, false},
+		{"bare CR in request line", "GET /\r HTTP/1.1\r\nHost: example.com\r\n\r\n", true, false},
+		{"mixed", "GET / HTTP/1.1\r\nHost: example.com\nAccept: */*\r\n\r\n", true, false},
+		{"LF blank line", "GET / HTTP/1.1\r\nHost: example.com\r\n\n", true, false},
+	}
+	for _, strict := range []bool{false, true} {
+		s := newTestServer(t)
+		s.StrictLineEndings = strict
+		for _, tt := range tests {
+			want := tt.lenient
+			if strict {
+				want = tt.strict
+			}
+			_, err := parseRaw(s, tt.raw)
+			if (err == nil) != want {
+				t.Errorf("strict=%v %s: err = %v, want accepted=%v", strict, tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestStrictLineEndingsAnswers400(t *testing.T) {
+	s := newTestServer(t)
+	s.StrictLineEndings = true
+
+	response := exchange(t, s, "GET / HTTP/1.1\nHost: example.com\n\n")
+	if got := statusLine(response); got != "HTTP/1.1 400 Bad Request" {
+		t.Errorf("status = %q, want 400", got)
+	}
+}
+
+func TestOversizedHeaderAnswers431AndCloses(t *testing.T) {
+	s := newTestServer(t)
+
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Big: " + strings.Repeat("a", 1<<20) + "\r\n\r\n"
+	// exchange reads until the server closes the connection, so getting
+	// here at all means it did not wait for the rest of the header.
+	response := exchange(t, s, raw)
+	if got := statusLine(response); got != "HTTP/1.1 431 Request Header Fields Too Large" {
+		t.Errorf("status = %q, want 431", got)
+	}
+	if !strings.Contains(response, "Connection: close\r\n") {
+		t.Errorf("431 response does not announce the close")
+	}
+}
+
+// slowExchange sends each part of a request after the given pause and
+// returns the server's answer and how long it took to arrive.
+func slowExchange(t *testing.T, s *Server, pause time.Duration, parts ...string) (string, time.Duration) {
+	t.Helper()
+	client, server := net.Pipe()
+	start := time.Now()
+	go s.handleConnection(server)
+	defer client.Close()
+
+	client.SetDeadline(start.Add(5 * time.Second))
+	go func() {
+		for i, part := range parts {
+			if i > 0 {
+				time.Sleep(pause)
+			}
+			if _, err := io.WriteString(client, part); err != nil {
+				return
+			}
+		}
+	}()
+	out, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return string(out), time.Since(start)
+}
+
+func TestPartialHeaderTimesOut(t *testing.T) {
+	s := newTestServer(t)
+	s.HeaderTimeout = 100 * time.Millisecond
+
+	response, elapsed := slowExchange(t, s, 0, "GET / HTTP/1.1\r\nHost: exa")
+	if got := statusLine(response); got != "HTTP/1.1 408 Request Timeout" {
+		t.Errorf("status = %q, want 408", got)
+	}
+	if elapsed < s.HeaderTimeout || elapsed > s.HeaderTimeout+time.Second {
+		t.Errorf("answered after %v, want about the %v header timeout", elapsed, s.HeaderTimeout)
+	}
+}
+
+func TestSlowBodyIsBoundByReadTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.HeaderTimeout = 100 * time.Millisecond
+	s.ReadTimeout = time.Second
+	s.Handle("POST", "/echo", func(request *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: request.Body}
+	})
+	headers := "POST /echo HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"
+
+	// A body arriving after the header timeout but within the read
+	// timeout is still read.
+	response, _ := slowExchange(t, s, 3*s.HeaderTimeout, headers, "hello")
+	if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+		t.Errorf("slow body: status = %q, want 200", got)
+	}
+	if !strings.HasSuffix(response, "\r\n\r\nhello") {
+		t.Errorf("slow body: response = %q", response)
+	}
+
+	// A body that never arrives runs into the read timeout.
+	response, elapsed := slowExchange(t, s, 0, headers, "he")
+	if got := statusLine(response); got != "HTTP/1.1 408 Request Timeout" {
+		t.Errorf("stalled body: status = %q, want 408", got)
+	}
+	if elapsed < s.ReadTimeout || elapsed > s.ReadTimeout+time.Second {
+		t.Errorf("stalled body answered after %v, want about the %v read timeout", elapsed, s.ReadTimeout)
+	}
+}
+
+func TestRequestLogUsesReasonSent(t *testing.T) {
+	s := newTestServer(t)
+	var logged bytes.Buffer
+	s.Logger = stdLogger{log.New(&logged, "", 0)}
+	s.Handle("GET", "/busy", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: http.StatusTooManyRequests, Reason: "Slow Down", ContentType: "text/plain"}
+	})
+
+	response := exchange(t, s, "GET /busy HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 429 Slow Down" {
+		t.Fatalf("status = %q, want 429 Slow Down", got)
+	}
+	if !strings.Contains(logged.String(), "GET /busy - 429 Slow Down") {
+		t.Errorf("request log does not carry the reason sent:\n%s", logged.String())
+	}
+}
+
+func TestHeadersFileKeysAreCanonical(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.Root, HeadersFileName), []byte("cache-control: max-age=60\nX-FRAME-options: DENY\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := s.directoryHeaders(s.Root)
+	want := map[string]string{"Cache-Control": "max-age=60", "X-Frame-Options": "DENY"}
+	if len(headers) != len(want) {
+		t.Fatalf("headers = %v, want %v", headers, want)
+	}
+	for key, value := range want {
+		if headers[key] != value {
+			t.Errorf("%s = %q, want %q (headers %v)", key, headers[key], value, headers)
+		}
+	}
+}
+
+func TestContentETagSurvivesTouch(t *testing.T) {
+	s := newTestServer(t)
+	s.ContentETags = true
+	page := filepath.Join(s.Root, "page.html")
+	if err := os.WriteFile(page, []byte("<p>one</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	etag := func(extra string) (string, string) {
+		response := exchange(t, s, "GET /page.html HTTP/1.1\r\nHost: x\r\nConnection: close\r\n"+extra+"\r\n")
+		for _, line := range strings.Split(response, "\r\n") {
+			if value, ok := strings.CutPrefix(line, "ETag: "); ok {
+				return statusLine(response), value
+			}
+		}
+		t.Fatalf("no ETag in %q", response)
+		return "", ""
+	}
+
+	_, tag := etag("")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(page, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if status, got := etag("If-None-Match: " + tag + "\r\n"); status != "HTTP/1.1 304 Not Modified" || got != tag {
+		t.Errorf("after touching the file: %q with ETag %s, want 304 with %s", status, got, tag)
+	}
+
+	// The content keeps its size, but rewriting moves the mtime back to
+	// now, so the cached hash is dropped.
+	if err := os.WriteFile(page, []byte("<p>two</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, got := etag("If-None-Match: " + tag + "\r\n"); status != "HTTP/1.1 200 OK" || got == tag {
+		t.Errorf("after changing the content: %q with ETag %s, want 200 with a new tag", status, got)
+	}
+}
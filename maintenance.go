@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceRecheck bounds how long the scheduler sleeps, so wall-clock
+// jumps and DST changes are noticed within a minute.
+const maintenanceRecheck = time.Minute
+
+// MaintenanceWindow is a daily period of planned downtime, starting Start
+// after local midnight and lasting Duration. Windows may cross midnight.
+type MaintenanceWindow struct {
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// ParseMaintenanceWindow parses "HH:MM/DURATION", e.g. "02:00/30m".
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	clock, duration, ok := strings.Cut(spec, "/")
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: want HH:MM/DURATION", spec)
+	}
+
+	start, err := time.Parse("15:04", clock)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: bad start time: %v", spec, err)
+	}
+
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 || d >= 24*time.Hour {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: duration must be between 0 and 24h", spec)
+	}
+
+	return MaintenanceWindow{
+		Start:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		Duration: d,
+	}, nil
+}
+
+// SetMaintenance switches maintenance mode on or off. While it is on every
+// request on the main port is answered with 503, except registered static
+// responses such as health checks; the admin listener is unaffected.
+// MaintenanceWindows do not change it: the server is also in maintenance
+// during a window, whatever was set here.
+func (s *Server) SetMaintenance(on bool) {
+	if switchFlag(&s.maintenance, on) {
+		if on {
+			s.Logger.Infof("Entering maintenance mode")
+		} else {
+			s.Logger.Infof("Leaving maintenance mode")
+		}
+	}
+}
+
+// InMaintenance reports whether maintenance mode is on, set with
+// SetMaintenance or by a scheduled window.
+func (s *Server) InMaintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1 || atomic.LoadInt32(&s.scheduledMaintenance) == 1
+}
+
+func (s *Server) setScheduledMaintenance(on bool) {
+	if switchFlag(&s.scheduledMaintenance, on) {
+		if on {
+			s.Logger.Infof("Maintenance window started")
+		} else {
+			s.Logger.Infof("Maintenance window ended")
+		}
+	}
+}
+
+// switchFlag sets flag to on and reports whether that changed it.
+func switchFlag(flag *int32, on bool) bool {
+	var v int32
+	if on {
+		v = 1
+	}
+	return atomic.SwapInt32(flag, v) != v
+}
+
+// maintenanceResponse is the 503 sent during maintenance. Inside a
+// scheduled window Retry-After points at the window's end.
+func (s *Server) maintenanceResponse() *HTTPResponse {
+	retry := s.RetryAfter
+	if active, next := s.maintenanceState(s.now()); active {
+		retry = next
+	}
+
+	response := s.createErrorResponse(StatusServiceUnavailable, "Down for maintenance, please try again later")
+	response.Headers["Retry-After"] = retryAfterSeconds(retry)
+	return response
+}
+
+// retryAfterSeconds formats d as a Retry-After delay, rounded up to whole
+// seconds and at least one.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(int((d+time.Second-1)/time.Second), 1))
+}
+
+// runMaintenanceScheduler flips scheduled maintenance on and off at the
+// edges of the configured MaintenanceWindows until the server stops. It
+// leaves the mode set with SetMaintenance alone.
+func (s *Server) runMaintenanceScheduler() {
+	for {
+		active, next := s.maintenanceState(s.now())
+		s.setScheduledMaintenance(active)
+
+		timer := time.NewTimer(min(next, maintenanceRecheck))
+		select {
+		case <-s.acceptDone:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// maintenanceState reports whether now falls inside a window and how long
+// until the next window starts or the current one ends.
+func (s *Server) maintenanceState(now time.Time) (bool, time.Duration) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	active := false
+	var next time.Duration = -1
+	for _, w := range s.MaintenanceWindows {
+		// Yesterday's occurrence matters for windows that cross midnight.
+		for day := -1; day <= 1; day++ {
+			start := midnight.AddDate(0, 0, day).Add(w.Start)
+			end := start.Add(w.Duration)
+
+			var edge time.Time
+			switch {
+			case !now.Before(start) && now.Before(end):
+				active = true
+				edge = end
+			case now.Before(start):
+				edge = start
+			default:
+				continue
+			}
+
+			if d := edge.Sub(now); next < 0 || d < next {
+				next = d
+			}
+		}
+	}
+
+	if next < 0 {
+		next = maintenanceRecheck
+	}
+	return active, next
+}
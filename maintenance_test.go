@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceRetryAfter(t *testing.T) {
+	s := newTestServer(t)
+	s.RetryAfter = 30 * time.Second
+	s.SetMaintenance(true)
+
+	response := exchange(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 503 Service Unavailable" {
+		t.Fatalf("status = %q", got)
+	}
+	if !strings.Contains(response, "Retry-After: 30\r\n") {
+		t.Errorf("missing Retry-After: 30 in %q", response)
+	}
+
+	// Inside a scheduled window the hint is the time left in it.
+	s.MaintenanceWindows = []MaintenanceWindow{{Start: 2 * time.Hour, Duration: 30 * time.Minute}}
+	s.Now = func() time.Time { return time.Date(2026, 1, 1, 2, 20, 0, 500, time.Local) }
+	response = exchange(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if !strings.Contains(response, "Retry-After: 600\r\n") {
+		t.Errorf("missing Retry-After: 600 in %q", response)
+	}
+}
+
+func TestSchedulerKeepsManualMaintenance(t *testing.T) {
+	windows := []MaintenanceWindow{{Start: 2 * time.Hour, Duration: 30 * time.Minute}}
+	outside := func() time.Time { return time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local) }
+	inside := func() time.Time { return time.Date(2026, 1, 1, 2, 10, 0, 0, time.Local) }
+
+	// Manual maintenance survives the scheduler finding no window open.
+	s := newTestServer(t)
+	s.MaintenanceWindows = windows
+	s.Now = outside
+	s.SetMaintenance(true)
+	startServer(t, s)
+	time.Sleep(50 * time.Millisecond)
+	if !s.InMaintenance() {
+		t.Error("scheduler outside a window switched manual maintenance off")
+	}
+
+	// A window puts the server in maintenance without touching the
+	// manual setting, and leaving it manually does not end the window.
+	s = newTestServer(t)
+	s.MaintenanceWindows = windows
+	s.Now = inside
+	startServer(t, s)
+	deadline := time.Now().Add(time.Second)
+	for !s.InMaintenance() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.SetMaintenance(false)
+	if !s.InMaintenance() {
+		t.Error("not in maintenance inside a scheduled window")
+	}
+}
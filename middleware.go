@@ -0,0 +1,44 @@
+package main
+
+// Middleware wraps a handler, typically calling next and inspecting or
+// modifying the request before and the response after.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware around routes and static file serving. The first
+// one registered is the outermost, so middlewares see the request in
+// registration order and the response in reverse.
+func (s *Server) Use(mw Middleware) {
+	s.middlewareMu.Lock()
+	defer s.middlewareMu.Unlock()
+	s.middleware = append(s.middleware, mw)
+}
+
+// dispatch runs handleRequest through the middleware chain.
+func (s *Server) dispatch(request *HTTPRequest) *HTTPResponse {
+	return s.dispatchTo(request, s.handleRequest)
+}
+
+// dispatchTo runs handler through the middleware chain.
+func (s *Server) dispatchTo(request *HTTPRequest, handler HandlerFunc) *HTTPResponse {
+	s.middlewareMu.RLock()
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	s.middlewareMu.RUnlock()
+
+	response := handler(request)
+	if response == nil {
+		s.Logger.Errorf("Middleware returned no response for %s %s", request.Method, request.Path)
+		return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	return response
+}
+
+func (s *Server) hasMiddleware() bool {
+	s.middlewareMu.RLock()
+	defer s.middlewareMu.RUnlock()
+	return len(s.middleware) > 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+var mimeTypes = map[string]string{
+	".html": "text/html",
+	".htm":  "text/html",
+	".css":  "text/css",
+	".js":   "application/javascript",
+	".json": "application/json",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".ico":  "image/x-icon",
+	".txt":  "text/plain",
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+}
+
+// SetMimeType sets the Content-Type served for files with extension ext
+// (".svg" or "svg", any case), overriding the built-in table.
+func (s *Server) SetMimeType(ext, mimeType string) {
+	s.mimeMu.Lock()
+	defer s.mimeMu.Unlock()
+
+	if s.mimeTypes == nil {
+		s.mimeTypes = make(map[string]string)
+	}
+	s.mimeTypes[normalizeExt(ext)] = mimeType
+}
+
+// getMimeType looks the extension up in the types set with SetMimeType,
+// then the built-in table, then the system table via mime.TypeByExtension.
+func (s *Server) getMimeType(filePath string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext == "" && s.ExtensionlessType != "" {
+		return s.ExtensionlessType
+	}
+
+	s.mimeMu.RLock()
+	mimeType, exists := s.mimeTypes[ext]
+	s.mimeMu.RUnlock()
+	if exists {
+		return mimeType
+	}
+
+	if mimeType, exists := mimeTypes[ext]; exists {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(ext); ext != "" && mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
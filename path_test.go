@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePathContainment(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "www")
+
+	tests := []struct {
+		requestPath string
+		want        string
+		ok          bool
+	}{
+		{"/index.html", "index.html", true},
+		{"/docs/./guide.html", "docs/guide.html", true},
+		{"/docs/../index.html", "index.html", true},
+		{"/..hidden..txt", "..hidden..txt", true},
+		{"/../etc/passwd", "", false},
+		{"../../etc/passwd", "", false},
+		{"/docs/../../www-other/secret", "", false},
+		{"/..", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := resolvePath(root, tt.requestPath)
+		if ok != tt.ok {
+			t.Errorf("resolvePath(%q) ok = %v, want %v", tt.requestPath, ok, tt.ok)
+			continue
+		}
+		if ok && got != filepath.Join(root, filepath.FromSlash(tt.want)) {
+			t.Errorf("resolvePath(%q) = %q, want %q under the root", tt.requestPath, got, tt.want)
+		}
+	}
+}
+
+func TestTraversalRequestsStayInsideRoot(t *testing.T) {
+	s := newTestServer(t)
+	dir := filepath.Dir(s.Root)
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(s.Root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Root, "docs", "guide.txt"), []byte("the guide"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) string {
+		return exchange(t, s, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	}
+
+	for _, target := range []string{
+		"/../secret.txt",
+		"/docs/../../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E/secret.txt",
+		"/%2e%2e%2fsecret.txt",
+		"/docs/%2e%2e/%2e%2e/secret.txt",
+		"/..%5csecret.txt",
+	} {
+		response := get(target)
+		if strings.Contains(response, "top secret") {
+			t.Errorf("%s served a file outside the root", target)
+		}
+		if code := statusLine(response); !strings.Contains(code, " 404 ") && !strings.Contains(code, " 403 ") && !strings.Contains(code, " 400 ") {
+			t.Errorf("%s: status = %q, want 400, 403 or 404", target, code)
+		}
+	}
+
+	for _, target := range []string{"/docs/./guide.txt", "/./docs/guide.txt", "/docs/%2e/guide.txt"} {
+		response := get(target)
+		if statusLine(response) != "HTTP/1.1 200 OK" || !strings.HasSuffix(response, "the guide") {
+			t.Errorf("%s: got %.120q, want the guide", target, response)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+// applyProblemDetails replaces the HTML body of an error page with an
+// RFC 7807 problem document when ProblemDetails is enabled and the client
+// prefers JSON over HTML. Minimal error pages stay empty.
+func (s *Server) applyProblemDetails(request *HTTPRequest, response *HTTPResponse) {
+	if !s.ProblemDetails || response.errorMessage == "" || len(response.Body) == 0 {
+		return
+	}
+	if !prefersProblemJSON(request.Headers["accept"]) {
+		return
+	}
+
+	code, title := response.Code, statusText(response.Code)
+	if response.Reason != "" {
+		title = response.Reason
+	}
+	detail := response.errorMessage
+	if detail == title {
+		detail = ""
+	}
+
+	body, err := json.Marshal(struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail,omitempty"`
+	}{"about:blank", title, code, detail})
+	if err != nil {
+		return
+	}
+
+	response.ContentType = problemContentType
+	response.Body = append(body, '\n')
+}
+
+// prefersProblemJSON reports whether the Accept header ranks
+// application/problem+json (or plain application/json) above HTML.
+func prefersProblemJSON(accept string) bool {
+	if accept == "" {
+		return false
+	}
+
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		weight := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if w, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = w
+				}
+			}
+		}
+		q[mediaType] = weight
+	}
+
+	weight := func(types ...string) float64 {
+		for _, t := range types {
+			if w, ok := q[t]; ok {
+				return w
+			}
+		}
+		return 0
+	}
+
+	jsonWeight := max(weight(problemContentType), weight("application/json"))
+	return jsonWeight > 0 && jsonWeight > weight("text/html", "text/*", "*/*")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+)
+
+// resolveClient fills in ClientIP, Scheme, Host and TLS. The first three
+// describe the TCP peer unless the peer is one of TrustedProxies, in which
+// case they are taken from the Forwarded header (RFC 7239) or, failing
+// that, from X-Forwarded-For/-Proto/-Host.
+func (s *Server) resolveClient(conn net.Conn, request *HTTPRequest) {
+	request.ClientIP, _, _ = net.SplitHostPort(request.RemoteAddr)
+	request.Scheme = "http"
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		request.Scheme = "https"
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+		if len(state.VerifiedChains) > 0 {
+			request.ClientSubject = state.VerifiedChains[0][0].Subject.String()
+		}
+	}
+	request.Host = request.Headers["host"]
+
+	if !s.isTrustedProxy(request.ClientIP) {
+		return
+	}
+
+	var hops []forwardedHop
+	if header := request.Headers["forwarded"]; header != "" {
+		hops = parseForwarded(header)
+	} else if header := request.Headers["x-forwarded-for"]; header != "" {
+		// X-Forwarded-Proto/-Host are single values set by the edge proxy,
+		// so they apply to whichever hop turns out to be the client.
+		proto := strings.ToLower(strings.TrimSpace(request.Headers["x-forwarded-proto"]))
+		host := strings.TrimSpace(request.Headers["x-forwarded-host"])
+		for _, addr := range strings.Split(header, ",") {
+			hops = append(hops, forwardedHop{forIP: parseForwardedFor(addr), proto: proto, host: host})
+		}
+	}
+
+	// Walk back from the hop nearest to us; the first address that is not
+	// one of our own proxies is the client. Anything further left was
+	// supplied by the client and cannot be trusted.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop.forIP == "" {
+			return
+		}
+		request.ClientIP = hop.forIP
+		if hop.proto == "http" || hop.proto == "https" {
+			request.Scheme = hop.proto
+		}
+		if hop.host != "" {
+			request.Host = hop.host
+		}
+		if !s.isTrustedProxy(hop.forIP) {
+			return
+		}
+	}
+}
+
+func (s *Server) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ipInList(ip, s.TrustedProxies)
+}
+
+type forwardedHop struct {
+	forIP string
+	proto string
+	host  string
+}
+
+// parseForwarded splits a Forwarded header into one hop per element,
+// e.g. `for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"`.
+func parseForwarded(header string) []forwardedHop {
+	var hops []forwardedHop
+	for _, element := range strings.Split(header, ",") {
+		var hop forwardedHop
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			switch strings.ToLower(key) {
+			case "for":
+				hop.forIP = parseForwardedFor(value)
+			case "proto":
+				hop.proto = strings.ToLower(value)
+			case "host":
+				hop.host = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseForwardedFor extracts the IP from a node such as 192.0.2.43,
+// 192.0.2.43:8080 or [2001:db8::1]:4711. Obfuscated identifiers and
+// "unknown" yield "".
+func parseForwardedFor(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+
+	if ip := net.ParseIP(node); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// applyRange turns a full 200 file response into a 206 carrying the part
+// selected by the request's Range header. Only single byte ranges are
+// supported; anything else is ignored and the whole body is sent, as it
+// is when an If-Range validator no longer matches the file.
+func (s *Server) applyRange(request *HTTPRequest, response *HTTPResponse) *HTTPResponse {
+	if response.Code != StatusOK {
+		return response
+	}
+	response.Headers["Accept-Ranges"] = "bytes"
+
+	header := request.Headers["range"]
+	if header == "" {
+		return response
+	}
+	if ifRange, ok := request.Headers["if-range"]; ok && !ifRangeMatches(ifRange, response) {
+		return response
+	}
+
+	size := response.contentLength()
+	start, end, ok := parseByteRange(header, size)
+	if !ok {
+		return response
+	}
+
+	var file io.ReaderAt
+	if response.BodyReader != nil {
+		if file, ok = response.BodyReader.(io.ReaderAt); !ok {
+			return response
+		}
+	}
+
+	if start < 0 {
+		response.closeBody()
+		unsatisfiable := s.createErrorResponse(StatusRangeNotSatisfiable, "Range Not Satisfiable")
+		unsatisfiable.Headers["Content-Range"] = fmt.Sprintf("bytes */%d", size)
+		return unsatisfiable
+	}
+
+	response.Code = StatusPartialContent
+	response.Headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+	if file != nil {
+		closer, _ := response.BodyReader.(io.Closer)
+		response.BodyReader = sectionCloser{io.NewSectionReader(file, start, end-start+1), closer}
+		response.BodySize = end - start + 1
+	} else {
+		response.Body = response.Body[start : end+1]
+	}
+	return response
+}
+
+// ifRangeMatches reports whether an If-Range validator still describes
+// the response: its strong ETag, or exactly its Last-Modified date. A
+// client resuming a download of a file that has since changed gets the
+// whole new file rather than a part spliced onto the old one.
+func ifRangeMatches(validator string, response *HTTPResponse) bool {
+	validator = strings.TrimSpace(validator)
+	if strings.HasPrefix(validator, `"`) || strings.HasPrefix(validator, "W/") {
+		// Weak tags never match: a range needs the same bytes.
+		return validator == response.Headers["ETag"]
+	}
+
+	since, err := http.ParseTime(validator)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(response.Headers["Last-Modified"])
+	return err == nil && modified.Equal(since)
+}
+
+// parseByteRange parses "bytes=first-last", "bytes=first-" or
+// "bytes=-suffix" against a body of size bytes. ok is false for headers
+// that should be ignored (other units, several ranges, bad syntax);
+// start is -1 when the range is well-formed but unsatisfiable.
+func parseByteRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, false
+		}
+		if suffix == 0 || size == 0 {
+			return -1, 0, true
+		}
+		return max(size-suffix, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		header     string
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-3", 0, 3, true},
+		{"bytes=2-2", 2, 2, true},
+		{"bytes=4-", 4, 9, true},
+		{"bytes=4-100", 4, 9, true},
+		{"bytes=-3", 7, 9, true},
+		{"bytes=-100", 0, 9, true},
+		{"bytes= 1-2", 1, 2, true},
+
+		// Well-formed but unsatisfiable.
+		{"bytes=10-", -1, 0, true},
+		{"bytes=10-20", -1, 0, true},
+		{"bytes=-0", -1, 0, true},
+
+		// Ignored: the whole body is sent.
+		{"bytes=0-1,4-5", 0, 0, false},
+		{"bytes=-1,-2", 0, 0, false},
+		{"items=0-3", 0, 0, false},
+		{"bytes=3-1", 0, 0, false},
+		{"bytes=a-3", 0, 0, false},
+		{"bytes=0-b", 0, 0, false},
+		{"bytes=-x", 0, 0, false},
+		{"bytes=--3", 0, 0, false},
+		{"bytes=5", 0, 0, false},
+		{"bytes=", 0, 0, false},
+	} {
+		start, end, ok := parseByteRange(tc.header, 10)
+		if ok != tc.ok || (ok && (start != tc.start || end != tc.end)) {
+			t.Errorf("%q: got %d-%d ok=%v, want %d-%d ok=%v", tc.header, start, end, ok, tc.start, tc.end, tc.ok)
+		}
+	}
+
+	if start, _, ok := parseByteRange("bytes=-5", 0); !ok || start != -1 {
+		t.Errorf("suffix range of an empty body: start %d ok=%v, want unsatisfiable", start, ok)
+	}
+}
+
+func TestRangeResponses(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.Root, "file.txt"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		rangeHeader  string
+		status       string
+		contentRange string
+		body         string
+	}{
+		{"bytes=2-5", "206 Partial Content", "bytes 2-5/10", "2345"},
+		{"bytes=7-", "206 Partial Content", "bytes 7-9/10", "789"},
+		{"bytes=-2", "206 Partial Content", "bytes 8-9/10", "89"},
+		{"bytes=0-1,5-6", "200 OK", "", "0123456789"},
+		{"bytes=x-y", "200 OK", "", "0123456789"},
+		{"bytes=10-", "416 Range Not Satisfiable", "bytes */10", ""},
+		{"bytes=-0", "416 Range Not Satisfiable", "bytes */10", ""},
+	} {
+		response := exchange(t, s, "GET /file.txt HTTP/1.1\r\nHost: x\r\nRange: "+tc.rangeHeader+"\r\nConnection: close\r\n\r\n")
+		if got := statusLine(response); got != "HTTP/1.1 "+tc.status {
+			t.Errorf("%s: status = %q, want %s", tc.rangeHeader, got, tc.status)
+			continue
+		}
+		contentRange := regexp.MustCompile(`\r\nContent-Range: ([^\r]*)\r\n`).FindStringSubmatch(response)
+		switch {
+		case tc.contentRange == "" && contentRange != nil:
+			t.Errorf("%s: unexpected Content-Range %q", tc.rangeHeader, contentRange[1])
+		case tc.contentRange != "" && (contentRange == nil || contentRange[1] != tc.contentRange):
+			t.Errorf("%s: Content-Range in %q, want %q", tc.rangeHeader, response, tc.contentRange)
+		}
+		if tc.body != "" && !strings.HasSuffix(response, "\r\n\r\n"+tc.body) {
+			t.Errorf("%s: body of %q, want %q", tc.rangeHeader, response, tc.body)
+		}
+		if tc.body != "" && !strings.Contains(response, "\r\nContent-Length: "+strconv.Itoa(len(tc.body))+"\r\n") {
+			t.Errorf("%s: Content-Length in %q, want %d", tc.rangeHeader, response, len(tc.body))
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	s := newTestServer(t)
+	modTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	file := filepath.Join(s.Root, "file.txt")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ifRange string) string {
+		return exchange(t, s, "GET /file.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-3\r\nIf-Range: "+ifRange+"\r\nConnection: close\r\n\r\n")
+	}
+	tag := regexp.MustCompile(`\r\nETag: ("[^"]+")\r\n`).FindStringSubmatch(exchange(t, s, "GET /file.txt HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	if tag == nil {
+		t.Fatal("file response has no ETag")
+	}
+
+	for _, tc := range []struct {
+		ifRange string
+		status  string
+	}{
+		{tag[1], "206 Partial Content"},
+		{modTime.Format(http.TimeFormat), "206 Partial Content"},
+		{`"stale"`, "200 OK"},
+		{"W/" + tag[1], "200 OK"},
+		{modTime.Add(-time.Hour).Format(http.TimeFormat), "200 OK"},
+		{"not a validator", "200 OK"},
+	} {
+		if got := statusLine(get(tc.ifRange)); got != "HTTP/1.1 "+tc.status {
+			t.Errorf("If-Range %s: status = %q, want %s", tc.ifRange, got, tc.status)
+		}
+	}
+}
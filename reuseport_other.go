@@ -0,0 +1,13 @@
+//go:build !unix || solaris
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// HandlerFunc produces the response to a request.
+type HandlerFunc func(*HTTPRequest) *HTTPResponse
+
+type routeKey struct {
+	method string
+	path   string
+}
+
+// route is a handler for a routeKey that applies only when every header
+// in headers, keyed by lowercase name, has exactly the given value.
+type route struct {
+	headers map[string]string
+	fn      HandlerFunc
+}
+
+func (r route) matches(request *HTTPRequest) bool {
+	for name, value := range r.headers {
+		if got, ok := request.Headers[name]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Handle registers fn for requests with exactly this method and path,
+// ahead of static files. A GET route also answers HEAD unless a HEAD
+// route is registered. If fn returns nil, static file serving proceeds
+// as if there were no route.
+func (s *Server) Handle(method, path string, fn HandlerFunc) {
+	s.HandleWhen(method, path, nil, fn)
+}
+
+// HandleWhen is Handle for requests that also carry each of headers with
+// exactly the given value, e.g. {"X-Env": "staging"} or {"Host":
+// "canary.example.com"}. Header names are case-insensitive.
+//
+// When several routes for a method and path match, the most specific one
+// wins: the route with the most header predicates, and among equally
+// specific ones, the first registered. A plain Handle route has none and
+// so is the fallback. Registering the same predicates again replaces the
+// handler.
+func (s *Server) HandleWhen(method, path string, headers map[string]string, fn HandlerFunc) {
+	predicates := make(map[string]string, len(headers))
+	for name, value := range headers {
+		predicates[strings.ToLower(name)] = value
+	}
+
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+
+	if s.routes == nil {
+		s.routes = make(map[routeKey][]route)
+	}
+	key := routeKey{strings.ToUpper(method), path}
+	routes := s.routes[key]
+	for i, existing := range routes {
+		if samePredicates(existing.headers, predicates) {
+			routes[i].fn = fn
+			return
+		}
+	}
+	routes = append(routes, route{headers: predicates, fn: fn})
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].headers) > len(routes[j].headers)
+	})
+	s.routes[key] = routes
+}
+
+func samePredicates(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRoute returns the handler of the most specific route for key that
+// the request satisfies. Routes are kept most specific first.
+func (s *Server) matchRoute(key routeKey, request *HTTPRequest) (HandlerFunc, bool) {
+	for _, r := range s.routes[key] {
+		if r.matches(request) {
+			return r.fn, true
+		}
+	}
+	return nil, false
+}
+
+// routeResponse runs the route registered for the request, if any. When
+// the path is routed for other methods only, it answers 405. Routes whose
+// header predicates the request does not satisfy count as absent.
+func (s *Server) routeResponse(request *HTTPRequest, requestPath string) *HTTPResponse {
+	s.routesMu.RLock()
+	fn, ok := s.matchRoute(routeKey{request.Method, requestPath}, request)
+	if !ok && request.Method == "HEAD" {
+		fn, ok = s.matchRoute(routeKey{"GET", requestPath}, request)
+	}
+	var allowed []string
+	if !ok {
+		for key := range s.routes {
+			if key.path != requestPath {
+				continue
+			}
+			if _, matched := s.matchRoute(key, request); matched {
+				allowed = append(allowed, key.method)
+			}
+		}
+	}
+	s.routesMu.RUnlock()
+
+	if !ok {
+		if len(allowed) == 0 {
+			return nil
+		}
+		sort.Strings(allowed)
+		response := s.createErrorResponse(StatusMethodNotAllowed, "Method Not Allowed")
+		response.Headers["Allow"] = strings.Join(allowed, ", ")
+		return response
+	}
+
+	response := fn(request)
+	if response != nil {
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.route = requestPath
+	}
+	return response
+}
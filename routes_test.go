@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderRoutesMostSpecificWins(t *testing.T) {
+	s := newTestServer(t)
+	text := func(body string) HandlerFunc {
+		return func(*HTTPRequest) *HTTPResponse {
+			return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte(body)}
+		}
+	}
+	s.Handle("GET", "/app", text("default"))
+	s.HandleWhen("GET", "/app", map[string]string{"X-Env": "staging"}, text("staging"))
+	s.HandleWhen("GET", "/app", map[string]string{"X-Env": "staging", "X-Canary": "1"}, text("canary"))
+	s.HandleWhen("POST", "/app", map[string]string{"X-Env": "staging"}, text("posted"))
+
+	for _, tc := range []struct {
+		request string
+		want    string
+	}{
+		{"GET /app HTTP/1.1\r\n", "default"},
+		{"GET /app HTTP/1.1\r\nx-env: staging\r\n", "staging"},
+		{"GET /app HTTP/1.1\r\nX-Canary: 1\r\nX-Env: staging\r\n", "canary"},
+		{"GET /app HTTP/1.1\r\nX-Canary: 1\r\n", "default"},
+		{"GET /app HTTP/1.1\r\nX-Env: production\r\n", "default"},
+		{"POST /app HTTP/1.1\r\nX-Env: staging\r\nContent-Length: 0\r\n", "posted"},
+	} {
+		response := exchange(t, s, tc.request+"Host: x\r\nConnection: close\r\n\r\n")
+		if !strings.HasSuffix(response, "\r\n\r\n"+tc.want) {
+			t.Errorf("%q: got %q, want body %q", tc.request, response, tc.want)
+		}
+	}
+
+	// Without the header the POST route does not apply, so only GET is
+	// allowed.
+	response := exchange(t, s, "POST /app HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 405 Method Not Allowed" {
+		t.Fatalf("status = %q, want 405", got)
+	}
+	if !strings.Contains(response, "\r\nAllow: GET\r\n") {
+		t.Errorf("Allow missing or wrong in %q", response)
+	}
+}
+
+func TestHandleWhenReplacesSamePredicates(t *testing.T) {
+	s := newTestServer(t)
+	for _, body := range []string{"old", "new"} {
+		body := body
+		s.HandleWhen("GET", "/v", map[string]string{"x-env": "staging"}, func(*HTTPRequest) *HTTPResponse {
+			return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte(body)}
+		})
+	}
+
+	response := exchange(t, s, "GET /v HTTP/1.1\r\nHost: x\r\nX-Env: staging\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(response, "\r\n\r\nnew") {
+		t.Errorf("got %q, want the handler registered last", response)
+	}
+}
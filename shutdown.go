@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// Shutdown stops the server gracefully: it stops accepting, lets idle
+// keep-alive connections go and waits for in-flight requests to finish.
+// If ctx ends first the remaining connections are closed and an error
+// reports how many were abandoned. Start returns once Shutdown is done;
+// cancelling the context passed to Start has the same effect.
+// Only the first call does the work; later calls wait for it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+		close(s.stopped)
+	})
+	<-s.stopped
+	return s.shutdownErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	s.Logger.Infof("Shutting down server...")
+
+	s.startMu.Lock()
+	serving := s.serving
+	s.closeListeners()
+
+	if s.admin != nil {
+		s.admin.Close()
+	}
+
+	if s.acme != nil {
+		s.acme.Close()
+	}
+	s.startMu.Unlock()
+
+	s.closeIdleConns()
+
+	// Once the accept loop has exited no further conns.Add can race with
+	// the Wait below, so every accepted connection is accounted for. A
+	// server that was never started, or failed to, has no accept loop to
+	// wait for.
+	var err error
+	if serving {
+		select {
+		case <-s.acceptDone:
+			err = s.waitForConnections(ctx)
+		case <-ctx.Done():
+			err = s.abandonConnections(ctx)
+		}
+	}
+
+	if s.archive != nil {
+		s.archive.Close()
+	}
+
+	s.printStats()
+	return err
+}
+
+func (s *Server) waitForConnections(ctx context.Context) error {
+	// Connections still waiting for a slot are not open yet but are in
+	// s.conns, so the wait happens even when none are counted as open.
+	active := atomic.LoadInt64(&s.Stats.OpenConnections)
+	if active > 0 {
+		if deadline, ok := ctx.Deadline(); ok {
+			s.Logger.Infof("Waiting until %s for %d in-flight connection(s) to finish...", deadline.Format("15:04:05"), active)
+		} else {
+			s.Logger.Infof("Waiting for %d in-flight connection(s) to finish...", active)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if active > 0 {
+			s.Logger.Infof("All connections finished")
+		}
+		return nil
+	case <-ctx.Done():
+		return s.abandonConnections(ctx)
+	}
+}
+
+// abandonConnections closes the connections still open once ctx has
+// ended and reports how many there were.
+func (s *Server) abandonConnections(ctx context.Context) error {
+	abandoned := s.closeActiveConns()
+	s.Logger.Errorf("Grace period expired, dropped %d connection(s)", abandoned)
+	return fmt.Errorf("shutdown abandoned %d connection(s): %w", abandoned, ctx.Err())
+}
+
+func (s *Server) trackConn(conn net.Conn, active bool) {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+
+	if !active {
+		delete(s.activeConns, conn)
+		return
+	}
+	if s.activeConns == nil {
+		s.activeConns = make(map[net.Conn]struct{})
+	}
+	s.activeConns[conn] = struct{}{}
+}
+
+func (s *Server) closeActiveConns() int {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+
+	for conn := range s.activeConns {
+		conn.Close()
+	}
+	return len(s.activeConns)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownUnderConcurrentLoad(t *testing.T) {
+	s := newTestServer(t)
+	var started sync.WaitGroup
+	s.Handle("GET", "/slow", func(*HTTPRequest) *HTTPResponse {
+		started.Done()
+		time.Sleep(200 * time.Millisecond)
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("done")}
+	})
+	addr, done := startServer(t, s)
+
+	const clients = 50
+	started.Add(clients)
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	var completed int64
+	var requests sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			resp, err := client.Get("http://" + addr + "/slow")
+			if err != nil {
+				t.Errorf("request: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil || resp.StatusCode != StatusOK || string(body) != "done" {
+				t.Errorf("response = %d %q, %v", resp.StatusCode, body, err)
+				return
+			}
+			atomic.AddInt64(&completed, 1)
+		}()
+	}
+	started.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	requests.Wait()
+
+	if completed != clients {
+		t.Errorf("%d of %d in-flight requests completed", completed, clients)
+	}
+	if got := atomic.LoadInt64(&s.Stats.OpenConnections); got != 0 {
+		t.Errorf("OpenConnections = %d after shutdown", got)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Start did not return after Shutdown")
+	}
+}
+
+func TestShutdownWithoutStartReturns(t *testing.T) {
+	s := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	returned := make(chan error, 1)
+	go func() { returned <- s.Shutdown(ctx) }()
+
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown of a server that was never started did not return")
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	s := newTestServer(t)
+	_, done := startServer(t, s)
+
+	if err := s.Start(context.Background()); !errors.Is(err, errAlreadyStarted) {
+		t.Errorf("second Start = %v, want %v", err, errAlreadyStarted)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("first Start = %v after a clean shutdown", err)
+	}
+}
+
+func TestStartReportsAbandonedConnections(t *testing.T) {
+	s := newTestServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	entered := make(chan struct{})
+	s.Handle("GET", "/stuck", func(*HTTPRequest) *HTTPResponse {
+		close(entered)
+		<-release
+		return &HTTPResponse{Code: StatusOK}
+	})
+	addr, done := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /stuck HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	shutdownErr := s.Shutdown(ctx)
+	if !errors.Is(shutdownErr, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want a deadline error", shutdownErr)
+	}
+
+	select {
+	case err := <-done:
+		if err != shutdownErr {
+			t.Errorf("Start = %v, want %v", err, shutdownErr)
+		}
+	case <-time.After(time.Second):
+		t.Error("Start did not return after Shutdown")
+	}
+}
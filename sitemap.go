@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SitemapPath            = "/sitemap.xml"
+	DefaultSitemapInterval = 5 * time.Minute
+	sitemapNamespace       = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type cachedSitemap struct {
+	body  []byte
+	built time.Time
+}
+
+// serveSitemap answers /sitemap.xml with every HTML page under the
+// document root, or under the root of the virtual host asked for, whose
+// URLs then use that host instead of SitemapBaseURL. Pages protected by
+// AuthRules are left out. Each walk is cached and redone at most once
+// per SitemapInterval.
+func (s *Server) serveSitemap(request *HTTPRequest) *HTTPResponse {
+	interval := s.SitemapInterval
+	if interval <= 0 {
+		interval = DefaultSitemapInterval
+	}
+
+	host, roots, base := "", s.roots(), s.SitemapBaseURL
+	if s.archive == nil && s.FS == nil {
+		if root, ok := s.vhostRoot(request); ok {
+			host = normalizeHost(request.Host)
+			roots = []string{root}
+			base = request.Scheme + "://" + request.Host
+		}
+	}
+
+	s.sitemapMu.Lock()
+	defer s.sitemapMu.Unlock()
+
+	cached := s.sitemaps[host]
+	if cached == nil || time.Since(cached.built) >= interval {
+		body, err := s.buildSitemap(roots, base)
+		if err != nil {
+			s.Logger.Errorf("Error generating sitemap: %v", err)
+			return s.createErrorResponse(StatusInternalServerError, "Internal Server Error")
+		}
+		if s.sitemaps == nil {
+			s.sitemaps = make(map[string]*cachedSitemap)
+		}
+		cached = &cachedSitemap{body: body, built: time.Now()}
+		s.sitemaps[host] = cached
+	}
+
+	return &HTTPResponse{
+		Code:        StatusOK,
+		ContentType: "application/xml; charset=utf-8",
+		Body:        cached.body,
+		Headers:     make(map[string]string),
+	}
+}
+
+func (s *Server) buildSitemap(roots []string, base string) ([]byte, error) {
+	pages := make(map[string]time.Time)
+
+	if s.archive != nil {
+		for name, entry := range s.archive.files {
+			if isSitemapPage(name) {
+				pages[name] = entry.modTime
+			}
+		}
+	} else if s.FS != nil {
+		err := fs.WalkDir(s.FS, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() || !isSitemapPage(p) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			pages[p] = info.ModTime()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Walk the layers back to front so that files in earlier layers,
+		// which shadow later ones when serving, win here too.
+		for i := len(roots) - 1; i >= 0; i-- {
+			root := roots[i]
+			err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+				if p != root && strings.HasPrefix(d.Name(), ".") {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					return nil
+				}
+				name := filepath.ToSlash(rel)
+				if !isSitemapPage(name) {
+					return nil
+				}
+
+				info, err := d.Info()
+				if err != nil {
+					return nil
+				}
+				pages[name] = info.ModTime()
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(pages))
+	for name := range pages {
+		if len(s.authRulesFor("/"+name)) == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	base = strings.TrimSuffix(base, "/")
+	set := sitemapURLSet{Xmlns: sitemapNamespace}
+	for _, name := range names {
+		urlPath := "/" + name
+		if path.Base(name) == "index.html" {
+			urlPath = strings.TrimSuffix(urlPath, "index.html")
+		}
+		entry := sitemapURL{Loc: base + urlPath}
+		if modTime := pages[name]; !modTime.IsZero() {
+			entry.LastMod = modTime.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, entry)
+	}
+
+	body, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+func isSitemapPage(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
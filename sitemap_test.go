@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("<html></html>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSitemapSkipsProtectedPagesAndFollowsVHosts(t *testing.T) {
+	s := newTestServer(t)
+	s.SitemapBaseURL = "https://example.com"
+	s.AuthRules = []AuthRule{{Prefix: "/private", Realm: "staff", Username: "u", Password: "p"}}
+	writeFiles(t, s.Root, "index.html", "about.html", "private/secret.html", "private/index.html")
+
+	blog := t.TempDir()
+	writeFiles(t, blog, "index.html", "post.html")
+	s.AddVHost("blog.example.com", blog)
+
+	get := func(host string) string {
+		return exchange(t, s, "GET /sitemap.xml HTTP/1.1\r\nHost: "+host+"\r\nConnection: close\r\n\r\n")
+	}
+
+	site := get("example.com")
+	for _, want := range []string{"<loc>https://example.com/</loc>", "<loc>https://example.com/about.html</loc>"} {
+		if !strings.Contains(site, want) {
+			t.Errorf("site sitemap is missing %s", want)
+		}
+	}
+	if strings.Contains(site, "private") {
+		t.Errorf("site sitemap lists protected pages:\n%s", site)
+	}
+	if strings.Contains(site, "post.html") {
+		t.Errorf("site sitemap lists the virtual host's pages:\n%s", site)
+	}
+
+	vhost := get("blog.example.com")
+	for _, want := range []string{"<loc>http://blog.example.com/</loc>", "<loc>http://blog.example.com/post.html</loc>"} {
+		if !strings.Contains(vhost, want) {
+			t.Errorf("virtual host sitemap is missing %s:\n%s", want, vhost)
+		}
+	}
+	if strings.Contains(vhost, "about.html") {
+		t.Errorf("virtual host sitemap lists the default site's pages:\n%s", vhost)
+	}
+}
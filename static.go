@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type staticResponse struct {
+	path        string
+	code        int
+	contentType string
+	head        []byte
+	fields      []byte
+	body        []byte
+}
+
+func (s *Server) RegisterStaticResponse(path string, status int, contentType string, body []byte) {
+	text := http.StatusText(status)
+	if text == "" {
+		panic(fmt.Sprintf("RegisterStaticResponse: invalid status code %d", status))
+	}
+
+	static := &staticResponse{path: path, code: status, contentType: contentType}
+	static.head = []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, text))
+
+	fields := fmt.Sprintf("Content-Type: %s\r\n", contentType)
+	fields += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+	static.fields = []byte(fields)
+	static.body = body
+
+	s.staticMu.Lock()
+	defer s.staticMu.Unlock()
+
+	if s.staticResponses == nil {
+		s.staticResponses = make(map[string]*staticResponse)
+	}
+	s.staticResponses[path] = static
+}
+
+func (s *Server) lookupStaticResponse(request *HTTPRequest) *staticResponse {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return nil
+	}
+
+	path, _, _ := strings.Cut(request.Path, "?")
+
+	s.staticMu.RLock()
+	defer s.staticMu.RUnlock()
+
+	return s.staticResponses[path]
+}
+
+// response is the static response as an ordinary HTTPResponse, for when
+// the request cannot take the fast path, such as HTTP/0.9 or a request
+// under an AuthRule that must be answered by handleRequest.
+func (static *staticResponse) response() *HTTPResponse {
+	return &HTTPResponse{
+		Code:        static.code,
+		ContentType: static.contentType,
+		Body:        static.body,
+		Headers:     make(map[string]string),
+	}
+}
+
+func (s *Server) sendStaticResponse(conn net.Conn, static *staticResponse, keepAlive bool, served int, omitBody bool) error {
+	buffers := net.Buffers{static.head, []byte("Server: " + s.serverHeader() + "\r\n")}
+	if s.PoweredBy != "" {
+		buffers = append(buffers, []byte("X-Powered-By: "+s.PoweredBy+"\r\n"))
+	}
+	if s.AltSvc != "" {
+		buffers = append(buffers, []byte("Alt-Svc: "+s.AltSvc+"\r\n"))
+	}
+	if !s.DisableDateHeader {
+		buffers = append(buffers, []byte("Date: "+s.now().UTC().Format(time.RFC1123)+"\r\n"))
+	}
+	buffers = append(buffers, static.fields)
+	buffers = append(buffers, []byte(s.connectionFields(keepAlive, served)+"\r\n"))
+	if !omitBody {
+		buffers = append(buffers, static.body)
+	}
+
+	_, err := buffers.WriteTo(conn)
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestStaticResponseHonoursAuthRules(t *testing.T) {
+	s := newTestServer(t)
+	s.RegisterStaticResponse("/private/health", StatusOK, "text/plain", []byte("ok"))
+	s.RegisterStaticResponse("/health", StatusOK, "text/plain", []byte("ok"))
+	s.AuthRules = []AuthRule{{Prefix: "/private", Realm: "ops", Username: "admin", Password: "secret"}}
+
+	tests := []struct {
+		name, path, authorization, want string
+	}{
+		{"public", "/health", "", "HTTP/1.1 200 OK"},
+		{"no credentials", "/private/health", "", "HTTP/1.1 401 Unauthorized"},
+		{"wrong password", "/private/health", "admin:nope", "HTTP/1.1 401 Unauthorized"},
+		{"credentials", "/private/health", "admin:secret", "HTTP/1.1 200 OK"},
+		{"encoded path", "/%70rivate/health", "", "HTTP/1.1 401 Unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET " + tt.path + " HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"
+			if tt.authorization != "" {
+				raw += "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(tt.authorization)) + "\r\n"
+			}
+			response := exchange(t, s, raw+"\r\n")
+			if got := statusLine(response); got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if strings.HasPrefix(tt.want, "HTTP/1.1 200") && !strings.HasSuffix(response, "\r\n\r\nok") {
+				t.Errorf("body missing from %q", response)
+			}
+		})
+	}
+}
+
+func TestStaticResponseRedirectsToHTTPS(t *testing.T) {
+	s := newTestServer(t)
+	s.RegisterStaticResponse("/health", StatusOK, "text/plain", []byte("ok"))
+	s.RedirectHTTPS = true
+	s.TLSPort = "443"
+
+	response := exchange(t, s, "GET /health HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if got := statusLine(response); got != "HTTP/1.1 301 Moved Permanently" {
+		t.Fatalf("status = %q, want 301", got)
+	}
+	if !strings.Contains(response, "Location: https://example.com/health\r\n") {
+		t.Errorf("missing HTTPS Location in %q", response)
+	}
+}
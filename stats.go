@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultStatsPath = "/server-status"
+
+// OtherRoute is the route the per-route stats count a request under when
+// no route or registered static response answered it, which keeps the
+// number of routes bounded by what was registered.
+const OtherRoute = "other"
+
+// RouteStats counts the requests answered by one route and the body bytes
+// they carried each way.
+type RouteStats struct {
+	Requests      int64 `json:"requests"`
+	RequestBytes  int64 `json:"request_bytes"`
+	ResponseBytes int64 `json:"response_bytes"`
+}
+
+func (st *ServerStats) countRoute(route string, requestBytes, responseBytes int64) {
+	if route == "" {
+		route = OtherRoute
+	}
+
+	st.routesMu.Lock()
+	defer st.routesMu.Unlock()
+
+	if st.routes == nil {
+		st.routes = make(map[string]*RouteStats)
+	}
+	counts, ok := st.routes[route]
+	if !ok {
+		counts = &RouteStats{}
+		st.routes[route] = counts
+	}
+	counts.Requests++
+	counts.RequestBytes += requestBytes
+	counts.ResponseBytes += responseBytes
+}
+
+// Routes returns a copy of the per-route counters, keyed by the path the
+// route was registered under or OtherRoute.
+func (st *ServerStats) Routes() map[string]RouteStats {
+	st.routesMu.Lock()
+	defer st.routesMu.Unlock()
+
+	routes := make(map[string]RouteStats, len(st.routes))
+	for route, counts := range st.routes {
+		routes[route] = *counts
+	}
+	return routes
+}
+
+func routeNames(routes map[string]RouteStats) []string {
+	names := make([]string, 0, len(routes))
+	for route := range routes {
+		names = append(names, route)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type statsSnapshot struct {
+	TotalRequests       int64   `json:"total_requests"`
+	SuccessfulRequests  int64   `json:"successful_requests"`
+	ErrorRequests       int64   `json:"error_requests"`
+	SuccessRate         float64 `json:"success_rate"`
+	UptimeSeconds       int64   `json:"uptime_seconds"`
+	OpenConnections     int64   `json:"open_connections"`
+	InFlightRequests    int64   `json:"in_flight_requests"`
+	RejectedConnections int64   `json:"rejected_connections"`
+	EvictedConnections  int64   `json:"evicted_connections"`
+	RequestsPerSecond   float64 `json:"requests_per_second"`
+
+	Routes map[string]RouteStats `json:"routes,omitempty"`
+}
+
+// internalResponse answers the opt-in version and stats endpoints. These
+// requests are not counted in the stats, but pass through middleware,
+// the HTTPS redirect and AuthRules like any other.
+func (s *Server) internalResponse(request *HTTPRequest) *HTTPResponse {
+	var endpoint func() *HTTPResponse
+	switch {
+	case s.isEndpointRequest(request, s.VersionPath):
+		endpoint = s.versionResponse
+	case s.isEndpointRequest(request, s.StatsPath):
+		endpoint = s.statsResponse
+	default:
+		return nil
+	}
+
+	return s.dispatchTo(request, func(request *HTTPRequest) *HTTPResponse {
+		if response := s.gateRequest(request); response != nil {
+			return response
+		}
+		return endpoint()
+	})
+}
+
+func (s *Server) statsResponse() *HTTPResponse {
+	snapshot := statsSnapshot{
+		TotalRequests:       atomic.LoadInt64(&s.Stats.TotalRequests),
+		SuccessfulRequests:  atomic.LoadInt64(&s.Stats.SuccessfulRequests),
+		ErrorRequests:       atomic.LoadInt64(&s.Stats.ErrorRequests),
+		UptimeSeconds:       int64(time.Since(s.Stats.StartTime).Seconds()),
+		OpenConnections:     atomic.LoadInt64(&s.Stats.OpenConnections),
+		InFlightRequests:    atomic.LoadInt64(&s.Stats.InFlightRequests),
+		RejectedConnections: atomic.LoadInt64(&s.Stats.RejectedConnections),
+		EvictedConnections:  atomic.LoadInt64(&s.Stats.EvictedConnections),
+		RequestsPerSecond:   s.Stats.RequestsPerSecond(),
+		Routes:              s.Stats.Routes(),
+	}
+	if snapshot.TotalRequests > 0 {
+		snapshot.SuccessRate = float64(snapshot.SuccessfulRequests) / float64(snapshot.TotalRequests) * 100
+	}
+
+	body, _ := json.Marshal(snapshot)
+	return &HTTPResponse{
+		Code:        StatusOK,
+		ContentType: "application/json",
+		Body:        append(body, '\n'),
+		Headers:     map[string]string{"Cache-Control": "no-store"},
+	}
+}
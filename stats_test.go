@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run with -race: the counters are bumped from every connection goroutine.
+func TestStatsCountConcurrentRequests(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("GET", "/ok", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("ok")}
+	})
+	addr, _ := startServer(t, s)
+
+	const clients, perClient = 20, 25
+	transport := &http.Transport{MaxIdleConnsPerHost: clients}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	var failed int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perClient; j++ {
+				path := "/ok"
+				if j%5 == 0 {
+					path = fmt.Sprintf("/missing-%d-%d", i, j)
+				}
+				resp, err := client.Get("http://" + addr + path)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		t.Fatalf("%d requests failed", failed)
+	}
+	total := int64(clients * perClient)
+	errors := total / 5
+	if got := atomic.LoadInt64(&s.Stats.TotalRequests); got != total {
+		t.Errorf("TotalRequests = %d, want %d", got, total)
+	}
+	if got := atomic.LoadInt64(&s.Stats.SuccessfulRequests); got != total-errors {
+		t.Errorf("SuccessfulRequests = %d, want %d", got, total-errors)
+	}
+	if got := atomic.LoadInt64(&s.Stats.ErrorRequests); got != errors {
+		t.Errorf("ErrorRequests = %d, want %d", got, errors)
+	}
+	if got := atomic.LoadInt64(&s.Stats.InFlightRequests); got != 0 {
+		t.Errorf("InFlightRequests = %d after all responses", got)
+	}
+}
+
+func TestInternalEndpointsAnswerHTTP09(t *testing.T) {
+	s := newTestServer(t)
+	s.AllowHTTP09 = true
+	s.VersionPath = "/version"
+	s.StatsPath = DefaultStatsPath
+
+	for _, path := range []string{s.VersionPath, s.StatsPath} {
+		response := exchange(t, s, "GET "+path+"\r\n")
+		if !strings.HasPrefix(response, "{") || !strings.HasSuffix(response, "}\n") {
+			t.Errorf("%s: got %q, want a bare JSON body", path, response)
+		}
+	}
+}
+
+func TestRouteStatsCountBodyBytes(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("POST", "/upload", func(request *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte("stored")}
+	})
+	s.RegisterStaticResponse("/health", StatusOK, "text/plain", []byte("ok"))
+
+	for _, raw := range []string{
+		"POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: 100\r\n\r\n" + strings.Repeat("a", 100),
+		"POST /upload?v=2 HTTP/1.1\r\nHost: x\r\nContent-Length: 50\r\n\r\n" + strings.Repeat("b", 50),
+		"GET /health HTTP/1.1\r\nHost: x\r\n\r\n",
+		"HEAD /health HTTP/1.1\r\nHost: x\r\n\r\n",
+		"GET /missing HTTP/1.1\r\nHost: x\r\n\r\n",
+	} {
+		exchange(t, s, strings.Replace(raw, "\r\n\r\n", "\r\nConnection: close\r\n\r\n", 1))
+	}
+
+	routes := s.Stats.Routes()
+	want := map[string]RouteStats{
+		"/upload": {Requests: 2, RequestBytes: 150, ResponseBytes: 12},
+		"/health": {Requests: 2, ResponseBytes: 2},
+	}
+	for route, counts := range want {
+		if routes[route] != counts {
+			t.Errorf("%s: %+v, want %+v", route, routes[route], counts)
+		}
+	}
+	if other := routes[OtherRoute]; other.Requests != 1 || other.ResponseBytes == 0 {
+		t.Errorf("%s: %+v, want the 404 page", OtherRoute, other)
+	}
+	if len(routes) != 3 {
+		t.Errorf("routes = %v, want only /upload, /health and %s", routes, OtherRoute)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`simplehttp_route_request_bytes_sum{route="/upload"} 150`,
+		`simplehttp_route_request_bytes_count{route="/upload"} 2`,
+		`simplehttp_route_response_bytes_sum{route="/health"} 2`,
+	} {
+		if !strings.Contains(recorder.Body.String(), line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// MinStreamSize is the size from which files are streamed from disk
+// rather than read into memory. Smaller files stay buffered so they can
+// still be compressed or rewritten before sending.
+const MinStreamSize = 1 << 20
+
+// streamFile reports whether a file of the given type and size is
+// streamed. Compressible files stay buffered up to MaxCompressSize so
+// gzipResponse can still compress them.
+func (s *Server) streamFile(contentType string, size int64) bool {
+	if s.Compress && isCompressible(contentType) {
+		return size >= MaxCompressSize
+	}
+	return size >= MinStreamSize
+}
+
+// contentLength is the size of the body that will be sent.
+func (r *HTTPResponse) contentLength() int64 {
+	if r.BodyReader != nil {
+		return r.BodySize
+	}
+	return int64(len(r.Body))
+}
+
+// closeBody releases a streamed body. It is safe to call more than once
+// and on responses without one.
+func (r *HTTPResponse) closeBody() {
+	if closer, ok := r.BodyReader.(io.Closer); ok {
+		closer.Close()
+	}
+	r.BodyReader = nil
+}
+
+// writeBody writes the response body to w, copying a streamed body
+// without holding it in memory. A body that turns out shorter than its
+// Content-Length is an error, as the connection can no longer be reused.
+func writeBody(w io.Writer, response *HTTPResponse) error {
+	if response.BodyReader == nil {
+		_, err := w.Write(response.Body)
+		return err
+	}
+
+	n, err := io.CopyN(w, response.BodyReader, response.BodySize)
+	if err == io.EOF {
+		return fmt.Errorf("body ended after %d of %d bytes, file changed while serving", n, response.BodySize)
+	}
+	return err
+}
+
+// sectionCloser is a range of a streamed file that closes the file.
+type sectionCloser struct {
+	*io.SectionReader
+	io.Closer
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// failingReader returns n bytes of data and then err.
+type failingReader struct {
+	n   int
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, r.err
+	}
+	n := min(len(p), r.n)
+	for i := range p[:n] {
+		p[i] = 'x'
+	}
+	r.n -= n
+	return n, nil
+}
+
+func TestStreamedBodyReadErrorClosesConnection(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("GET", "/video", func(*HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			Code:        StatusOK,
+			ContentType: "video/mp4",
+			BodyReader:  &failingReader{n: 10, err: errors.New("input/output error")},
+			BodySize:    100,
+		}
+	})
+
+	// Keep-alive is requested, so only the failure can close the connection.
+	response := exchange(t, s, "GET /video HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	if got := statusLine(response); got != "HTTP/1.1 200 OK" {
+		t.Fatalf("status = %q", got)
+	}
+	if !strings.Contains(response, "Content-Length: 100\r\n") {
+		t.Errorf("missing Content-Length in %q", response)
+	}
+	_, body, _ := strings.Cut(response, "\r\n\r\n")
+	if body != strings.Repeat("x", 10) {
+		t.Errorf("body = %q, want the 10 bytes read before the error", body)
+	}
+	if got := atomic.LoadInt64(&s.Stats.ErrorRequests); got != 1 {
+		t.Errorf("ErrorRequests = %d, want 1", got)
+	}
+	if got := atomic.LoadInt64(&s.Stats.SuccessfulRequests); got != 0 {
+		t.Errorf("SuccessfulRequests = %d, want 0", got)
+	}
+}
+
+func TestWriteBodyReportsShortFile(t *testing.T) {
+	response := &HTTPResponse{BodyReader: strings.NewReader("short"), BodySize: 10}
+	err := writeBody(io.Discard, response)
+	if err == nil || !strings.Contains(err.Error(), "5 of 10 bytes") {
+		t.Errorf("writeBody = %v, want a short body error", err)
+	}
+}
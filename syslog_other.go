@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func NewSyslogLogger(spec, tag string) (Logger, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
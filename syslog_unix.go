@@ -0,0 +1,86 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+var syslogPriorities = map[string]syslog.Priority{
+	"debug":  syslog.LOG_DEBUG,
+	"info":   syslog.LOG_INFO,
+	"notice": syslog.LOG_NOTICE,
+}
+
+type syslogLogger struct {
+	writer   *syslog.Writer
+	info     syslog.Priority
+	fallback Logger
+}
+
+// NewSyslogLogger connects to the local syslog daemon. spec takes the
+// syslog.conf form "facility[.priority]", e.g. "local0" or "daemon.notice";
+// the priority applies to access and informational lines, errors are
+// always sent at LOG_ERR. Lines that cannot be delivered later on go to
+// the standard logger instead.
+func NewSyslogLogger(spec, tag string) (Logger, error) {
+	facilityName, priorityName, _ := strings.Cut(spec, ".")
+
+	facility, ok := syslogFacilities[facilityName]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facilityName)
+	}
+
+	info := syslog.LOG_INFO
+	if priorityName != "" {
+		if info, ok = syslogPriorities[priorityName]; !ok {
+			return nil, fmt.Errorf("unknown syslog priority %q: want debug, info or notice", priorityName)
+		}
+	}
+
+	writer, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogLogger{writer: writer, info: info, fallback: stdLogger{log.Default()}}, nil
+}
+
+func (l *syslogLogger) Infof(format string, args ...any) {
+	var err error
+	message := fmt.Sprintf(format, args...)
+	switch l.info {
+	case syslog.LOG_DEBUG:
+		err = l.writer.Debug(message)
+	case syslog.LOG_NOTICE:
+		err = l.writer.Notice(message)
+	default:
+		err = l.writer.Info(message)
+	}
+	if err != nil {
+		l.fallback.Infof(format, args...)
+	}
+}
+
+func (l *syslogLogger) Errorf(format string, args ...any) {
+	if err := l.writer.Err(fmt.Sprintf(format, args...)); err != nil {
+		l.fallback.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxTestModeDelay = 30 * time.Second
+
+// applyTestMode honours the __delay and __status query parameters used by
+// QA to exercise client retry and timeout handling. It is a no-op unless
+// Server.TestMode is enabled and the client is trusted.
+func (s *Server) applyTestMode(request *HTTPRequest, query string) *HTTPResponse {
+	if query == "" || !strings.Contains(query, "__") {
+		return nil
+	}
+
+	if !s.isTestModeClient(request.RemoteAddr) {
+		return nil
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil
+	}
+
+	if delay := values.Get("__delay"); delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return s.createErrorResponse(StatusBadRequest, "Invalid __delay value")
+		}
+		time.Sleep(min(d, MaxTestModeDelay))
+	}
+
+	if status := values.Get("__status"); status != "" {
+		code, err := strconv.Atoi(status)
+		// 1xx, 204 and 304 responses cannot carry the error page body.
+		if err != nil || http.StatusText(code) == "" || code < 200 || code == http.StatusNoContent || code == StatusNotModified {
+			return s.createErrorResponse(StatusBadRequest, "Invalid __status value")
+		}
+		return s.createErrorResponse(code, "Test mode response")
+	}
+
+	return nil
+}
+
+func (s *Server) isTestModeClient(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	if len(s.TestModeClients) == 0 {
+		return ip.IsLoopback()
+	}
+	return ipInList(ip, s.TestModeClients)
+}
+
+func ipInList(ip net.IP, list []string) bool {
+	for _, entry := range list {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
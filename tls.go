@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// StartTLS is Start with CertFile and KeyFile set. Without a TLSPort the
+// main port speaks HTTPS only; with one, HTTPS is served there alongside
+// plain HTTP on Port.
+func (s *Server) StartTLS(ctx context.Context, certFile, keyFile string) error {
+	s.CertFile = certFile
+	s.KeyFile = keyFile
+	return s.Start(ctx)
+}
+
+func (s *Server) startTLS(lc net.ListenConfig) error {
+	if s.CertFile == "" || s.KeyFile == "" {
+		return fmt.Errorf("HTTPS needs both a certificate and a key file")
+	}
+	if s.RedirectHTTPS && s.TLSPort == "" {
+		return fmt.Errorf("redirecting to HTTPS needs a separate TLS port")
+	}
+	if len(s.ACMEDomains) > 0 {
+		return fmt.Errorf("a certificate file and ACME cannot be used together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if s.ClientCAFile != "" {
+		pool, err := loadCertPool(s.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		s.Logger.Infof("Client certificates required, CAs from %s", s.ClientCAFile)
+	}
+
+	if s.TLSPort == "" {
+		for i, listener := range s.listeners {
+			s.listeners[i] = tls.NewListener(listener, tlsConfig)
+		}
+		s.Logger.Infof("HTTPS enabled on port %s", s.Port)
+		return nil
+	}
+
+	listeners, err := s.listen(lc, s.TLSPort)
+	if err != nil {
+		return err
+	}
+	for _, listener := range listeners {
+		s.listeners = append(s.listeners, tls.NewListener(listener, tlsConfig))
+	}
+	s.Logger.Infof("HTTPS enabled on port %s", s.TLSPort)
+	return nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", file)
+	}
+	return pool, nil
+}
+
+// httpsRedirect sends a plain HTTP request to the same URL on TLSPort. It
+// returns nil when the request already arrived over HTTPS, directly or
+// through a trusted proxy.
+func (s *Server) httpsRedirect(request *HTTPRequest) *HTTPResponse {
+	if !s.RedirectHTTPS || request.Scheme == "https" {
+		return nil
+	}
+
+	if request.Host == "" {
+		return s.createErrorResponse(StatusBadRequest, "Bad Request")
+	}
+	// An IPv6 literal comes bracketed, "[::1]" or "[::1]:8080", and must
+	// stay so in the URL even without a port.
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	location := "https://" + net.JoinHostPort(host, s.TLSPort)
+	if s.TLSPort == "443" {
+		location = strings.TrimSuffix(location, ":443")
+	}
+	return s.createRedirectResponse(StatusMovedPermanently, location+request.Path)
+}
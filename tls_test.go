@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for name signed by the CA, usable by a
+// server at 127.0.0.1 or by a client.
+func (ca *testCA) issue(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestClientCertificatesRequired(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	serverCert, serverKey := ca.issue(t, "server")
+
+	s := newTestServer(t)
+	s.CertFile = write("server.pem", serverCert)
+	s.KeyFile = write("server-key.pem", serverKey)
+	s.ClientCAFile = write("ca.pem", ca.pem)
+	s.Handle("GET", "/whoami", func(request *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{Code: StatusOK, ContentType: "text/plain", Body: []byte(request.ClientSubject)}
+	})
+	addr, _ := startServer(t, s)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + addr + "/whoami")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	clientCert, clientKey := ca.issue(t, "alice")
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := get(pair)
+	if err != nil {
+		t.Fatalf("request with a client certificate: %v", err)
+	}
+	if subject != "CN=alice,O=Example" {
+		t.Errorf("ClientSubject = %q, want CN=alice,O=Example", subject)
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+
+	other, otherKey := newTestCA(t).issue(t, "mallory")
+	pair, err = tls.X509KeyPair(other, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(pair); err == nil {
+		t.Error("request with a certificate from another CA succeeded")
+	}
+}
+
+func TestHTTPSRedirectKeepsIPv6Brackets(t *testing.T) {
+	s := newTestServer(t)
+	s.RedirectHTTPS = true
+
+	for _, tc := range []struct {
+		tlsPort, host, want string
+	}{
+		{"443", "example.com:8080", "https://example.com/p"},
+		{"443", "[::1]:8080", "https://[::1]/p"},
+		{"443", "[::1]", "https://[::1]/p"},
+		{"8443", "example.com", "https://example.com:8443/p"},
+		{"8443", "[::1]:8080", "https://[::1]:8443/p"},
+		{"8443", "[2001:db8::1]", "https://[2001:db8::1]:8443/p"},
+	} {
+		s.TLSPort = tc.tlsPort
+		response := s.httpsRedirect(&HTTPRequest{Method: "GET", Path: "/p", Host: tc.host, Scheme: "http"})
+		if got := response.Headers["Location"]; got != tc.want {
+			t.Errorf("Host %s, TLS port %s: Location = %q, want %q", tc.host, tc.tlsPort, got, tc.want)
+		}
+	}
+}
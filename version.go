@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const DefaultVersionPath = "/version"
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.Version=v1.2.0 -X main.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+func (s *Server) serverHeader() string {
+	if s.ServerHeaderVersion {
+		return ServerName + " (" + Version + ")"
+	}
+	return ServerName
+}
+
+func (s *Server) isEndpointRequest(request *HTTPRequest, endpointPath string) bool {
+	if endpointPath == "" || (request.Method != "GET" && request.Method != "HEAD") {
+		return false
+	}
+	requestPath, _, _ := strings.Cut(request.Path, "?")
+	return requestPath == endpointPath
+}
+
+func (s *Server) versionResponse() *HTTPResponse {
+	body, _ := json.Marshal(map[string]string{
+		"version":    Version,
+		"commit":     Commit,
+		"build_date": BuildDate,
+	})
+
+	return &HTTPResponse{
+		Code:        StatusOK,
+		ContentType: "application/json",
+		Body:        append(body, '\n'),
+		Headers:     map[string]string{"Cache-Control": "no-store"},
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// AddVHost serves requests whose Host is host (any case, any port) from
+// root instead of the default Root or RootLayers.
+func (s *Server) AddVHost(host, root string) {
+	s.vhostMu.Lock()
+	defer s.vhostMu.Unlock()
+
+	if s.vhosts == nil {
+		s.vhosts = make(map[string]string)
+	}
+	s.vhosts[normalizeHost(host)] = root
+}
+
+// rootsFor returns the document roots for the request's host. It is empty
+// when the host has no virtual host and there is no default root, so
+// that nothing is served relative to the working directory.
+func (s *Server) rootsFor(request *HTTPRequest) []string {
+	if root, ok := s.vhostRoot(request); ok {
+		return []string{root}
+	}
+	if s.Root == "" && len(s.RootLayers) == 0 {
+		return nil
+	}
+	return s.roots()
+}
+
+// vhostRoot returns the root of the virtual host the request is for.
+func (s *Server) vhostRoot(request *HTTPRequest) (string, bool) {
+	s.vhostMu.RLock()
+	defer s.vhostMu.RUnlock()
+
+	root, ok := s.vhosts[normalizeHost(request.Host)]
+	return root, ok
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}